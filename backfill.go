@@ -0,0 +1,175 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Largest count (backfill) parameter accepted by Twitter, for elevated Firehose access
+	MaxBackfillCount = 150000
+)
+
+// Activity of each stream a client has connected to, shared with the client's clones.
+type streamActivities struct {
+	mu      sync.Mutex
+	streams map[string]*streamActivity
+}
+
+// Rate of messages received on a stream, used to size the backfill when reconnecting.
+type streamActivity struct {
+	mu sync.Mutex
+	// Tweets received on the last connection to receive any, and when the first and last
+	// of them arrived
+	messages    int64
+	first, last time.Time
+	// Whether a new connection has yet to receive a tweet
+	reconnected bool
+	// Number of connections made to the stream
+	connections int
+}
+
+// Returns the activity of a stream, identified by its URL and parameters.
+func (a *streamActivities) get(stream *TwitterAPIURL, formValues url.Values) *streamActivity {
+	values := url.Values{}
+	for k, v := range formValues {
+		if k != "count" {
+			values[k] = v
+		}
+	}
+	key := stream.URL + "?" + values.Encode()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.streams == nil {
+		a.streams = make(map[string]*streamActivity)
+	}
+	activity, ok := a.streams[key]
+	if !ok {
+		activity = new(streamActivity)
+		a.streams[key] = activity
+	}
+	return activity
+}
+
+// Records a new connection to the stream, returning the number of tweets estimated to
+// have been missed since the last one.
+func (sa *streamActivity) reconnect(now time.Time) int {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	sa.reconnected = true
+	sa.connections++
+
+	if sa.messages < 2 || !sa.last.After(sa.first) {
+		return 0
+	}
+	rate := float64(sa.messages-1) / sa.last.Sub(sa.first).Seconds()
+	return int(math.Ceil(rate * now.Sub(sa.last).Seconds()))
+}
+
+// Reports whether the stream has been connected to more than once.
+func (sa *streamActivity) reconnecting() bool {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	return sa.connections > 1
+}
+
+// Records a tweet received on the current connection.
+func (sa *streamActivity) received(now time.Time) {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	if sa.reconnected {
+		sa.reconnected = false
+		sa.messages = 0
+		sa.first = now
+	}
+	sa.messages++
+	sa.last = now
+}
+
+// Returns the form values for a connection to a stream, with a count parameter to
+// backfill the tweets missed since the last connection when s.BackfillLimit is set.
+func (s *StreamClient) backfill(activity *streamActivity, formValues url.Values) url.Values {
+	missed := activity.reconnect(time.Now())
+	limit := s.BackfillLimit
+	if limit > MaxBackfillCount {
+		limit = MaxBackfillCount
+	}
+	if limit <= 0 || missed <= 0 || formValues.Get("count") != "" {
+		return formValues
+	}
+	if missed > limit {
+		missed = limit
+	}
+
+	values := url.Values{}
+	for k, v := range formValues {
+		values[k] = v
+	}
+	values.Set("count", strconv.Itoa(missed))
+	return values
+}
+
+// PartitionValues returns the form values for each of n partitioned connections to a
+// stream, numbered from 1 to n by the partition parameter.
+func PartitionValues(formValues url.Values, n int) ([]url.Values, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("at least 1 partition is required, got %d", n)
+	}
+	partitions := make([]url.Values, n)
+	for i := range partitions {
+		values := url.Values{}
+		for k, v := range formValues {
+			values[k] = v
+		}
+		values.Set("partition", strconv.Itoa(i+1))
+		partitions[i] = values
+	}
+	return partitions, nil
+}
+
+// StreamPartitions opens n partitioned connections to a stream (such as the Firehose) and
+// sends the tweets received on all of them on tweets.  Errors from each connection are sent
+// on client.Errors, and client.Finished once every connection has ended.  CloseStreams
+// disconnects every partition.
+func (s *StreamClient) StreamPartitions(tweets chan<- *TwitterStatus, stream *TwitterAPIURL, formValues *url.Values, n int) {
+	partitions, err := PartitionValues(*formValues, n)
+	if err != nil {
+		s.Errors <- err
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, values := range partitions {
+		client := s.clone()
+		s.addClone(client)
+		wg.Add(1)
+		go func(values url.Values) {
+			defer wg.Done()
+			defer s.removeClone(client)
+			ended := make(chan struct{})
+			go func() {
+				client.Stream(tweets, stream, &values)
+				close(ended)
+			}()
+			for {
+				select {
+				case err := <-client.Errors:
+					s.Errors <- err
+				case <-client.Finished:
+				case <-ended:
+					return
+				}
+			}
+		}(values)
+	}
+	wg.Wait()
+	s.Finished <- struct{}{}
+}
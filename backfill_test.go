@@ -0,0 +1,191 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/garyburd/go-oauth/oauth"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStreamActivityReconnect(t *testing.T) {
+	now := time.Now()
+	activity := &streamActivity{}
+	if missed := activity.reconnect(now); missed != 0 {
+		t.Errorf("Expecting nothing missed on the first connection, got %v", missed)
+	}
+
+	// 10 tweets a second, then disconnected for 30 seconds
+	for i := 0; i <= 100; i++ {
+		activity.received(now.Add(time.Duration(i) * 100 * time.Millisecond))
+	}
+	if missed := activity.reconnect(now.Add(40 * time.Second)); missed != 300 {
+		t.Errorf("Expecting 300 missed, got %v", missed)
+	}
+	// The rate is kept until the new connection receives a tweet
+	if missed := activity.reconnect(now.Add(50 * time.Second)); missed != 400 {
+		t.Errorf("Expecting 400 missed, got %v", missed)
+	}
+	activity.received(now.Add(60 * time.Second))
+	if activity.messages != 1 {
+		t.Errorf("Expecting the new connection to be measured, got %v messages", activity.messages)
+	}
+}
+
+func TestBackfillLimits(t *testing.T) {
+	testData := []struct {
+		limit  int
+		values url.Values
+		e      string
+	}{
+		{0, url.Values{}, ""},
+		{100, url.Values{}, "100"},
+		{1000000, url.Values{}, "150000"},
+		{100, url.Values{"count": {"5"}}, "5"},
+	}
+
+	for _, d := range testData {
+		// Missed around 200,000 tweets
+		activity := &streamActivity{messages: 20001, first: time.Now().Add(-110 * time.Second), last: time.Now().Add(-100 * time.Second)}
+		client := NewClient()
+		client.BackfillLimit = d.limit
+		values := client.backfill(activity, d.values)
+		if count := values.Get("count"); count != d.e {
+			t.Errorf("Limit %v: expecting count %q, got %q", d.limit, d.e, count)
+		}
+	}
+}
+
+func TestStreamBackfillsOnReconnect(t *testing.T) {
+	var mu sync.Mutex
+	counts := []string{}
+	handler := func(_ *http.Client, _ *oauth.Credentials, _ string, values url.Values) (*http.Response, error) {
+		mu.Lock()
+		counts = append(counts, values.Get("count"))
+		mu.Unlock()
+		body := bytes.NewBufferString("{\"id_str\":\"1\"}\r\n{\"id_str\":\"2\"}\r\n{\"id_str\":\"3\"}\r\n")
+		return &http.Response{Body: ioutil.NopCloser(body)}, nil
+	}
+	testurl := &TwitterAPIURL{
+		AccessMethod:  "custom",
+		CustomHandler: handler,
+	}
+
+	client := NewClient()
+	client.BackfillLimit = 1000
+	formValues := &url.Values{"track": {"golang"}}
+	for i := 0; i < 2; i++ {
+		tweets := make(chan *TwitterStatus)
+		go client.Stream(tweets, testurl, formValues)
+	Receive:
+		for {
+			select {
+			case <-tweets:
+			case <-client.Errors:
+			case <-client.Finished:
+				break Receive
+			case <-time.After(time.Second):
+				t.Fatal("Stream timeout")
+			}
+		}
+
+		// 2 tweets a second, disconnected for a second
+		activity := client.activity.get(testurl, *formValues)
+		activity.first = time.Now().Add(-2 * time.Second)
+		activity.last = time.Now().Add(-time.Second)
+	}
+
+	if counts[0] != "" {
+		t.Errorf("Expecting no count on the first connection, got %v", counts[0])
+	}
+	if n, _ := strconv.Atoi(counts[1]); n < 2 || n > 4 {
+		t.Errorf("Expecting a count of about 2 on reconnecting, got %q", counts[1])
+	}
+	if formValues.Get("count") != "" {
+		t.Error("Stream modified the form values")
+	}
+}
+
+func TestStreamPartitions(t *testing.T) {
+	handler := func(_ *http.Client, _ *oauth.Credentials, _ string, values url.Values) (*http.Response, error) {
+		body := bytes.NewBufferString(fmt.Sprintf("{\"id_str\":\"%s\"}\r\n", values.Get("partition")))
+		return &http.Response{Body: ioutil.NopCloser(body)}, nil
+	}
+	testurl := &TwitterAPIURL{
+		AccessMethod:  "custom",
+		CustomHandler: handler,
+	}
+
+	client := NewClient()
+	tweets := make(chan *TwitterStatus)
+	go client.StreamPartitions(tweets, testurl, &url.Values{}, 3)
+
+	ids := []string{}
+	errs := 0
+	for {
+		select {
+		case status := <-tweets:
+			ids = append(ids, status.ID)
+		case <-client.Errors:
+			errs++
+		case <-client.Finished:
+			sort.Strings(ids)
+			if fmt.Sprint(ids) != "[1 2 3]" || errs != 3 {
+				t.Errorf("Unexpected partitions %v (%v errors)", ids, errs)
+			}
+			return
+		case <-time.After(time.Second):
+			t.Fatal("Stream timeout")
+		}
+	}
+}
+
+func TestPartitionValuesCount(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		if _, err := PartitionValues(url.Values{}, n); err == nil {
+			t.Errorf("Expecting error for %v partitions", n)
+		}
+	}
+}
+
+func TestStreamPartitionsClose(t *testing.T) {
+	var connected sync.WaitGroup
+	connected.Add(2)
+	handler := func(*http.Client, *oauth.Credentials, string, url.Values) (*http.Response, error) {
+		defer connected.Done()
+		pr, _ := io.Pipe()
+		return &http.Response{Body: pr}, nil
+	}
+	testurl := &TwitterAPIURL{
+		AccessMethod:  "custom",
+		CustomHandler: handler,
+	}
+
+	client := NewClient()
+	tweets := make(chan *TwitterStatus)
+	go client.StreamPartitions(tweets, testurl, &url.Values{}, 2)
+	go func() {
+		connected.Wait()
+		client.CloseStreams()
+	}()
+
+	for {
+		select {
+		case <-tweets:
+		case <-client.Errors:
+		case <-client.Finished:
+			return
+		case <-time.After(time.Second):
+			t.Fatal("Partitions not closed")
+		}
+	}
+}
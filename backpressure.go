@@ -0,0 +1,269 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// BackpressureMode decides what Stream does with messages when the tweets channel is not
+// being read fast enough and the buffer is full.
+type BackpressureMode int
+
+const (
+	// BlockWhenFull stops reading from Twitter until there is space in the buffer.
+	BlockWhenFull BackpressureMode = iota
+	// DropOldest discards the oldest buffered message to make space for the new one.
+	DropOldest
+	// DropNewest discards the newly received message.
+	DropNewest
+	// SpillToDisk writes messages which don't fit in the buffer to a temporary file.
+	SpillToDisk
+)
+
+// Backpressure configures a buffer between the stream reader and the tweets channel, so a
+// slow consumer doesn't stall the connection (Twitter disconnects clients which fall behind).
+type Backpressure struct {
+	Mode BackpressureMode
+	// Number of messages held in memory.  When 0 (the default) there is no buffer and each
+	// message is sent directly on the tweets channel.
+	Size int
+	// Directory used for SpillToDisk files (defaults to os.TempDir())
+	SpillDir string
+}
+
+// QueueStats contains counters for the buffer between the stream reader and the tweets channel.
+type QueueStats struct {
+	// Messages waiting to be sent on the tweets channel (including spilled messages)
+	Depth int64
+	// Messages discarded by DropOldest or DropNewest
+	Dropped int64
+	// Messages written to disk by SpillToDisk
+	Spilled int64
+}
+
+// QueueStats returns the buffer counters of all the client's streams.
+func (s *StreamClient) QueueStats() QueueStats {
+	return QueueStats{
+		Depth:   atomic.LoadInt64(&s.queueStats.Depth),
+		Dropped: atomic.LoadInt64(&s.queueStats.Dropped),
+		Spilled: atomic.LoadInt64(&s.queueStats.Spilled),
+	}
+}
+
+// Buffer of statuses waiting to be sent on the tweets channel.
+type statusQueue struct {
+	policy Backpressure
+	stats  *QueueStats
+	logger Logger
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  []*TwitterStatus
+	closed bool
+
+	// Messages which didn't fit within items, in the order they were received
+	spillFile     *os.File
+	spillReadOff  int64
+	spillWriteOff int64
+	spillPending  int
+}
+
+func newStatusQueue(policy Backpressure, stats *QueueStats) *statusQueue {
+	q := &statusQueue{
+		policy: policy,
+		stats:  stats,
+		items:  make([]*TwitterStatus, 0, policy.Size),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Adds a status to the queue, applying the backpressure policy if the queue is full.
+func (q *statusQueue) push(status *TwitterStatus) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	full := len(q.items) >= q.policy.Size
+	switch q.policy.Mode {
+	case DropNewest:
+		if full {
+			logEvent(q.logger, slog.LevelDebug, "message dropped", "id", status.ID)
+			status.Release()
+			atomic.AddInt64(&q.stats.Dropped, 1)
+			return nil
+		}
+	case DropOldest:
+		if full {
+			logEvent(q.logger, slog.LevelDebug, "message dropped", "id", q.items[0].ID)
+			q.items[0].Release()
+			q.items[0] = nil
+			q.items = q.items[1:]
+			atomic.AddInt64(&q.stats.Dropped, 1)
+			atomic.AddInt64(&q.stats.Depth, -1)
+		}
+	case SpillToDisk:
+		// Once spilling, everything goes to disk until it has been drained to keep the order
+		if full || q.spillPending > 0 {
+			if err := q.spill(status); err != nil {
+				return err
+			}
+			status.Release()
+			q.spillPending++
+			atomic.AddInt64(&q.stats.Spilled, 1)
+			atomic.AddInt64(&q.stats.Depth, 1)
+			q.cond.Broadcast()
+			return nil
+		}
+	default:
+		for len(q.items) >= q.policy.Size && !q.closed {
+			q.cond.Wait()
+		}
+	}
+
+	q.items = append(q.items, status)
+	atomic.AddInt64(&q.stats.Depth, 1)
+	q.cond.Broadcast()
+	return nil
+}
+
+// Removes the oldest status from the queue, waiting for one if the queue is empty.
+// ok is false once the queue is closed and empty.  status is nil if a spilled status
+// couldn't be read back.
+func (q *statusQueue) pop() (status *TwitterStatus, ok bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == 0 && q.spillPending == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.items) == 0 && q.spillPending > 0 {
+		if err := q.unspill(); err != nil {
+			return nil, true, err
+		}
+	}
+	if len(q.items) == 0 {
+		return nil, false, nil
+	}
+
+	status = q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+	atomic.AddInt64(&q.stats.Depth, -1)
+
+	// Refill from disk
+	if q.spillPending > 0 {
+		if err := q.unspill(); err != nil {
+			return status, true, err
+		}
+	}
+	q.cond.Broadcast()
+	return status, true, nil
+}
+
+// Marks the queue as closed, waking any waiting push or pop calls.
+func (q *statusQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Sends all queued statuses on tweets until the queue is closed and empty.
+func (q *statusQueue) forward(tweets chan<- *TwitterStatus, errs chan<- error) {
+	for {
+		status, ok, err := q.pop()
+		if err != nil {
+			errs <- err
+		}
+		if !ok {
+			break
+		}
+		if status != nil {
+			tweets <- status
+		}
+	}
+
+	if q.spillFile != nil {
+		q.spillFile.Close()
+		os.Remove(q.spillFile.Name())
+	}
+}
+
+// Writes a status to the spill file.  Each record is its length followed by the raw JSON.
+func (q *statusQueue) spill(status *TwitterStatus) error {
+	if q.spillFile == nil {
+		f, err := ioutil.TempFile(q.policy.SpillDir, "streamingtwitter-spill")
+		if err != nil {
+			return err
+		}
+		q.spillFile = f
+	}
+
+	raw := status.Raw()
+	if raw == nil {
+		var err error
+		if raw, err = json.Marshal(status); err != nil {
+			return err
+		}
+	}
+	record := make([]byte, 4+len(raw))
+	binary.BigEndian.PutUint32(record, uint32(len(raw)))
+	copy(record[4:], raw)
+
+	if _, err := q.spillFile.WriteAt(record, q.spillWriteOff); err != nil {
+		return err
+	}
+	q.spillWriteOff += int64(len(record))
+	return nil
+}
+
+// Moves the oldest spilled status into items.
+func (q *statusQueue) unspill() error {
+	size := make([]byte, 4)
+	if _, err := q.spillFile.ReadAt(size, q.spillReadOff); err != nil {
+		q.dropSpilled()
+		return err
+	}
+	raw := make([]byte, binary.BigEndian.Uint32(size))
+	if _, err := q.spillFile.ReadAt(raw, q.spillReadOff+4); err != nil {
+		q.dropSpilled()
+		return err
+	}
+	q.spillReadOff += int64(4 + len(raw))
+	q.spillPending--
+
+	// Start the file again once it has been drained
+	if q.spillPending == 0 {
+		q.spillReadOff, q.spillWriteOff = 0, 0
+		if err := q.spillFile.Truncate(0); err != nil {
+			return err
+		}
+	}
+
+	status := new(TwitterStatus)
+	if err := json.Unmarshal(raw, status); err != nil {
+		atomic.AddInt64(&q.stats.Depth, -1)
+		return err
+	}
+	q.items = append(q.items, status)
+	return nil
+}
+
+// Discards the spilled statuses once the spill file can't be read, so the queue carries
+// on with the statuses received from then on.
+func (q *statusQueue) dropSpilled() {
+	logEvent(q.logger, slog.LevelWarn, "spilled messages dropped", "count", q.spillPending)
+	atomic.AddInt64(&q.stats.Dropped, int64(q.spillPending))
+	atomic.AddInt64(&q.stats.Depth, -int64(q.spillPending))
+	q.spillPending = 0
+	q.spillReadOff, q.spillWriteOff = 0, 0
+	q.spillFile.Truncate(0)
+}
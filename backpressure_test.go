@@ -0,0 +1,238 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/garyburd/go-oauth/oauth"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+// Returns a decoded status with the given ID.
+func testStatus(t *testing.T, id int) *TwitterStatus {
+	status := new(TwitterStatus)
+	if err := json.Unmarshal([]byte(fmt.Sprintf(`{"id_str":"%d"}`, id)), status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+// Pops everything from a closed queue and returns the IDs.
+func drainQueue(t *testing.T, q *statusQueue) (ids []string) {
+	q.close()
+	for {
+		status, ok, err := q.pop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return
+		}
+		ids = append(ids, status.ID)
+	}
+}
+
+func TestQueueDropPolicies(t *testing.T) {
+	testData := []struct {
+		mode BackpressureMode
+		e    string
+	}{
+		{DropNewest, "[1 2 3]"},
+		{DropOldest, "[3 4 5]"},
+	}
+
+	for _, d := range testData {
+		stats := &QueueStats{}
+		q := newStatusQueue(Backpressure{Mode: d.mode, Size: 3}, stats)
+		for i := 1; i <= 5; i++ {
+			q.push(testStatus(t, i))
+		}
+		if stats.Depth != 3 || stats.Dropped != 2 {
+			t.Errorf("%v: unexpected stats %+v", d.mode, stats)
+		}
+		if ids := fmt.Sprint(drainQueue(t, q)); ids != d.e {
+			t.Errorf("%v: expecting %v, got %v", d.mode, d.e, ids)
+		}
+		if stats.Depth != 0 {
+			t.Errorf("%v: expecting empty queue, got depth %v", d.mode, stats.Depth)
+		}
+	}
+}
+
+func TestQueueBlocksWhenFull(t *testing.T) {
+	q := newStatusQueue(Backpressure{Mode: BlockWhenFull, Size: 1}, &QueueStats{})
+	q.push(testStatus(t, 1))
+
+	second := testStatus(t, 2)
+	pushed := make(chan struct{})
+	go func() {
+		q.push(second)
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("Push did not block on a full queue")
+	case <-time.After(5 * time.Millisecond):
+	}
+
+	q.pop()
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("Push not unblocked by pop")
+	}
+}
+
+func TestQueueSpillToDisk(t *testing.T) {
+	stats := &QueueStats{}
+	q := newStatusQueue(Backpressure{Mode: SpillToDisk, Size: 2, SpillDir: t.TempDir()}, stats)
+	for i := 1; i <= 4; i++ {
+		q.push(testStatus(t, i))
+	}
+	status, _, _ := q.pop()
+	// Must stay behind the spilled statuses
+	q.push(testStatus(t, 5))
+
+	if stats.Spilled != 3 || stats.Depth != 4 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if ids := fmt.Sprint(append([]string{status.ID}, drainQueue(t, q)...)); ids != "[1 2 3 4 5]" {
+		t.Errorf("Expecting [1 2 3 4 5], got %v", ids)
+	}
+}
+
+func TestQueueSpillReadError(t *testing.T) {
+	stats := &QueueStats{}
+	q := newStatusQueue(Backpressure{Mode: SpillToDisk, Size: 1, SpillDir: t.TempDir()}, stats)
+	for i := 1; i <= 3; i++ {
+		q.push(testStatus(t, i))
+	}
+	// Lose the spilled statuses
+	if err := os.Truncate(q.spillFile.Name(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tweets := make(chan *TwitterStatus)
+	errs := make(chan error)
+	done := make(chan struct{})
+	go func() {
+		q.forward(tweets, errs)
+		close(done)
+	}()
+
+	ids := []string{}
+	failed := false
+	timeout := time.After(time.Second)
+	for len(ids) < 2 {
+		select {
+		case status := <-tweets:
+			ids = append(ids, status.ID)
+		case <-errs:
+			failed = true
+			// The queue carries on with new statuses
+			q.push(testStatus(t, 4))
+		case <-timeout:
+			t.Fatalf("Queue stopped forwarding, received %v", ids)
+		}
+	}
+	q.close()
+	<-done
+
+	if !failed || fmt.Sprint(ids) != "[1 4]" {
+		t.Errorf("Expecting a read error and [1 4], got %v", ids)
+	}
+	if stats.Dropped != 2 || stats.Depth != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestStreamBackpressure(t *testing.T) {
+	handler := func(*http.Client, *oauth.Credentials, string, url.Values) (*http.Response, error) {
+		body := &bytes.Buffer{}
+		for i := 1; i <= 5; i++ {
+			fmt.Fprintf(body, "{\"id_str\":\"%d\"}\r\n", i)
+		}
+		return &http.Response{Body: ioutil.NopCloser(body)}, nil
+	}
+
+	testurl := &TwitterAPIURL{
+		AccessMethod:  "custom",
+		CustomHandler: handler,
+	}
+
+	client := NewClient()
+	client.Backpressure = Backpressure{Mode: SpillToDisk, Size: 1, SpillDir: t.TempDir()}
+	tweets := make(chan *TwitterStatus)
+	go client.Stream(tweets, testurl, &url.Values{})
+
+	ids := []string{}
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case status := <-tweets:
+			ids = append(ids, status.ID)
+			// Slow consumer
+			time.Sleep(time.Millisecond)
+		case <-client.Errors:
+		case <-client.Finished:
+			if fmt.Sprint(ids) != "[1 2 3 4 5]" {
+				t.Errorf("Expecting [1 2 3 4 5], got %v", ids)
+			}
+			if stats := client.QueueStats(); stats.Depth != 0 {
+				t.Errorf("Expecting empty queue, got %+v", stats)
+			}
+			return
+		case <-timeout:
+			t.Fatal("Stream timeout")
+		}
+	}
+}
+
+func TestQueueStatsSharedWithPartitions(t *testing.T) {
+	handler := func(*http.Client, *oauth.Credentials, string, url.Values) (*http.Response, error) {
+		body := &bytes.Buffer{}
+		for i := 1; i <= 5; i++ {
+			fmt.Fprintf(body, "{\"id_str\":\"%d\"}\r\n", i)
+		}
+		return &http.Response{Body: ioutil.NopCloser(body)}, nil
+	}
+	testurl := &TwitterAPIURL{
+		AccessMethod:  "custom",
+		CustomHandler: handler,
+	}
+
+	client := NewClient()
+	client.Backpressure = Backpressure{Mode: SpillToDisk, Size: 1, SpillDir: t.TempDir()}
+	tweets := make(chan *TwitterStatus)
+	go client.StreamPartitions(tweets, testurl, &url.Values{}, 2)
+
+	received := 0
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case <-tweets:
+			received++
+			// Slow consumer
+			time.Sleep(time.Millisecond)
+		case <-client.Errors:
+		case <-client.Finished:
+			if received != 10 {
+				t.Errorf("Expecting 10 statuses, got %v", received)
+			}
+			if stats := client.QueueStats(); stats.Spilled == 0 {
+				t.Errorf("Expecting spilled messages to be counted, got %+v", stats)
+			}
+			return
+		case <-timeout:
+			t.Fatal("Stream timeout")
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"encoding/json"
+	"github.com/garyburd/go-oauth/oauth"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Application-only authentication endpoints - https://dev.twitter.com/docs/auth/application-only-auth
+	bearerTokenURL      = "https://api.twitter.com/oauth2/token"
+	bearerInvalidateURL = "https://api.twitter.com/oauth2/invalidate_token"
+)
+
+// A BearerTokener provides an application-only (OAuth2) bearer token.  Requests made with
+// it are not in the context of a user, but have larger rate limits on many REST resources.
+type BearerTokener interface {
+	// BearerToken returns a valid bearer token for the app.
+	BearerToken() (string, error)
+}
+
+// AppOnlyTokens requests a bearer token from Twitter using the app's key and secret.
+type AppOnlyTokens struct {
+	// Token for the actual application
+	App *oauth.Credentials
+	// Bearer token, requested from Twitter when empty
+	Bearer string
+
+	// Token endpoints, the defaults are Twitter's oauth2/token and oauth2/invalidate_token
+	TokenURL      string
+	InvalidateURL string
+}
+
+// AuthenticateApp authenticates the app with Twitter using application-only authentication.
+// Requests made by the client are then signed with the bearer token instead of the
+// user's OAuth token.
+func (s *StreamClient) AuthenticateApp(t BearerTokener) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bearer, err = t.BearerToken()
+	return
+}
+
+// BearerToken returns the app's bearer token, requesting one from Twitter if necessary.
+func (a *AppOnlyTokens) BearerToken() (string, error) {
+	if a.Bearer != "" {
+		return a.Bearer, nil
+	}
+	if a.App == nil || a.App.Token == "" || a.App.Secret == "" {
+		return "", &ClientTokensError{
+			Msg: "missing app's Token or Secret",
+		}
+	}
+
+	tokenURL := a.TokenURL
+	if tokenURL == "" {
+		tokenURL = bearerTokenURL
+	}
+	resp, err := a.post(tokenURL, url.Values{"grant_type": {"client_credentials"}})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		TokenType   string `json:"token_type"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.TokenType != "bearer" || token.AccessToken == "" {
+		return "", &ClientTokensError{
+			Msg: "invalid bearer token response",
+		}
+	}
+
+	a.Bearer = token.AccessToken
+	return a.Bearer, nil
+}
+
+// Invalidate revokes the bearer token with Twitter.
+func (a *AppOnlyTokens) Invalidate() error {
+	if a.Bearer == "" {
+		return &ClientTokensError{
+			Msg: "no bearer token to invalidate",
+		}
+	}
+
+	invalidateURL := a.InvalidateURL
+	if invalidateURL == "" {
+		invalidateURL = bearerInvalidateURL
+	}
+	resp, err := a.post(invalidateURL, url.Values{"access_token": {a.Bearer}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	a.Bearer = ""
+	return nil
+}
+
+// Send a request to one of the oauth2 endpoints, authenticated with the app's key and secret.
+func (a *AppOnlyTokens) post(u string, form url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("POST", u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded;charset=UTF-8")
+	req.SetBasicAuth(url.QueryEscape(a.App.Token), url.QueryEscape(a.App.Secret))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, bearerError(resp)
+	}
+	return resp, nil
+}
+
+// Returns the first error from an oauth2 error response.
+func bearerError(resp *http.Response) error {
+	var body struct {
+		Errors []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || len(body.Errors) == 0 {
+		return &TwitterError{
+			ID:  resp.StatusCode,
+			Msg: http.StatusText(resp.StatusCode),
+		}
+	}
+	return &TwitterError{
+		ID:  body.Errors[0].Code,
+		Msg: body.Errors[0].Message,
+	}
+}
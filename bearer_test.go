@@ -0,0 +1,108 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"github.com/garyburd/go-oauth/oauth"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Starts a fake Twitter oauth2 server and returns AppOnlyTokens which use it.
+func newTestBearerServer(t *testing.T) (*httptest.Server, *AppOnlyTokens) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if user != "app-token" || pass != "app-secret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":[{"code":99,"label":"authenticity_token_error","message":"Unable to verify your credentials"}]}`))
+			return
+		}
+		w.Write([]byte(`{"token_type":"bearer","access_token":"bearer-token"}`))
+	})
+	mux.HandleFunc("/oauth2/invalidate_token", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token":"` + r.FormValue("access_token") + `"}`))
+	})
+	mux.HandleFunc("/1.1/users/lookup.json", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer bearer-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`[{"id_str":"` + r.FormValue("user_id") + `"}]`))
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	return ts, &AppOnlyTokens{
+		App:           testAppToken,
+		TokenURL:      ts.URL + "/oauth2/token",
+		InvalidateURL: ts.URL + "/oauth2/invalidate_token",
+	}
+}
+
+func TestAppOnlyTokensBearerToken(t *testing.T) {
+	_, tokens := newTestBearerServer(t)
+	bearer, err := tokens.BearerToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bearer != "bearer-token" {
+		t.Errorf("Expecting bearer-token, got %v", bearer)
+	}
+
+	if err := tokens.Invalidate(); err != nil {
+		t.Fatal(err)
+	}
+	if tokens.Bearer != "" {
+		t.Error("Bearer token not cleared after invalidating")
+	}
+	if err := tokens.Invalidate(); err == nil {
+		t.Error("Expecting error invalidating without a bearer token")
+	}
+}
+
+func TestAppOnlyTokensError(t *testing.T) {
+	_, tokens := newTestBearerServer(t)
+	tokens.App = &oauth.Credentials{
+		Token:  "app-token",
+		Secret: "wrong-secret",
+	}
+
+	_, err := tokens.BearerToken()
+	if rerr, ok := err.(*TwitterError); !ok || rerr.ID != 99 {
+		t.Errorf("Expecting TwitterError 99, got %v", err)
+	}
+
+	if _, err := (&AppOnlyTokens{}).BearerToken(); err == nil {
+		t.Error("Expecting error for missing app token")
+	}
+}
+
+func TestRestWithBearerToken(t *testing.T) {
+	ts, tokens := newTestBearerServer(t)
+	client := NewClient()
+	if err := client.AuthenticateApp(tokens); err != nil {
+		t.Fatal(err)
+	}
+
+	testurl := &TwitterAPIURL{
+		AccessMethod: "get",
+		URL:          ts.URL + "/1.1/users/lookup.json",
+	}
+	data := []TwitterUser{}
+	go client.Rest(&data, testurl, &url.Values{"user_id": {"123"}})
+
+	select {
+	case err := <-client.Errors:
+		t.Fatal(err)
+	case <-client.Finished:
+	case <-time.After(time.Second):
+		t.Fatal("Data not received on Finished channel")
+	}
+	if len(data) != 1 || data[0].ID != "123" {
+		t.Errorf("Unexpected response data %+v", data)
+	}
+}
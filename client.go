@@ -6,10 +6,15 @@
 package streamingtwitter
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/garyburd/go-oauth/oauth"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,6 +28,28 @@ const (
 type StreamClient struct {
 	oauthClient *oauth.Client
 	token       *oauth.Credentials
+	tokener     Tokener
+	// Guards token, which is replaced when re-authorizing
+	mu sync.Mutex
+	// Serialises re-authorizing, which can wait on the user and so mustn't hold mu
+	reauthorizing sync.Mutex
+	// Client used to send requests, wrapped by any middleware
+	httpClient *http.Client
+	middleware []Middleware
+	// Application-only bearer token, used instead of token when set
+	bearer string
+	// Bodies of the open streams, closed by CloseStreams
+	streams map[*http.Response]bool
+	// Whether the client has been shut down, so streams are closed as soon as they connect
+	closed bool
+	// Clones streaming on the client's behalf (by StreamPartitions), shut down by CloseStreams
+	clones map[*StreamClient]bool
+	// Message rates of the streams connected to, for backfilling on reconnect
+	activity *streamActivities
+	// Counters for Metrics
+	metrics *streamMetrics
+	// Counters for Backpressure buffers
+	queueStats *QueueStats
 
 	/* @todo Calling code should know which stream/request finishes or errors? */
 
@@ -30,12 +57,39 @@ type StreamClient struct {
 	Errors chan error
 	// When a request has finished, this channel will receive data.
 	Finished chan struct{}
+	// Withheld content notices received on a stream are sent here.  This is nil by default
+	// (and notices are discarded), create the channel to receive them.
+	Withheld chan *TwitterWithheldNotice
+	// Buffering between reading a stream and sending on its tweets channel (none by default).
+	Backpressure Backpressure
+	// Reuse statuses sent by Stream, to avoid an allocation per message.  Consumers must
+	// call Release() on each status once they are finished with it.
+	PoolStatuses bool
+	// Number of goroutines decoding stream messages.  With more than 1, Stream splits the
+	// stream into messages and decodes them in parallel.
+	DecodeWorkers int
+	// Send statuses decoded in parallel in the order they were received.  Otherwise they
+	// are sent as soon as they are decoded.
+	PreserveOrder bool
+	// Largest count parameter Stream adds when reconnecting to a stream, to backfill the
+	// tweets estimated to have been missed while disconnected.  Set it to the limit of the
+	// account's access level (at most MaxBackfillCount).  0 disables backfilling.
+	BackfillLimit int
+	// Request gzip compressed responses, which reduces the bandwidth used by streams by
+	// around 5 times at the cost of some CPU.
+	Gzip bool
+	// Receives events from the client's requests and streams (silent when nil).
+	Logger Logger
 }
 
 // A TwitterAPIURL provides details on how to access Twitter API URLs.
 type TwitterAPIURL struct {
-	// HTTP method which should be used to access the method (currently only get, post & custom is supported)
+	// HTTP method which should be used to access the method: get (the default), post, put,
+	// delete or custom.
 	AccessMethod string
+	// How the body of post & put requests is encoded: form (the default), json or multipart.
+	// json and multipart bodies are sent with RestBody, formValues are then sent in the query string.
+	BodyEncoding string
 	// If setting AccessMethod to custom then you must provide your own client handler.  Otherwise all
 	// requests go via the oauthClient.
 	CustomHandler func(*http.Client, *oauth.Credentials, string, url.Values) (*http.Response, error)
@@ -73,6 +127,15 @@ type TwitterStatus struct {
 	Coordinates           TwitterCoordinate      `json:"coordinates"`
 	Place                 TwitterPlace           `json:"place"`
 	Entities              TwitterEntity          `json:"entities"`
+	ExtendedEntities      TwitterExtendedEntity  `json:"extended_entities"`
+	WithheldInCountries   []string               `json:"withheld_in_countries"`
+	WithheldScope         string                 `json:"withheld_scope"`
+	WithheldCopyright     bool                   `json:"withheld_copyright"`
+
+	// Raw JSON message as it was received from Twitter.
+	raw json.RawMessage
+	// Whether the status came from the pool (see StreamClient.PoolStatuses)
+	pooled bool
 }
 
 // TwitterTime provides a timestamp.  It is seperate for easier JSON unmarshaling help.
@@ -118,25 +181,34 @@ type TwitterUser struct {
 	DefaultProfile                 bool                   `json:"default_profile"`
 	DefaultProfileImage            bool                   `json:"default_profile_image"`
 	Status                         map[string]interface{} `json:"status"`
+	WithheldInCountries            []string               `json:"withheld_in_countries"`
+	WithheldScope                  string                 `json:"withheld_scope"`
 }
 
-// TwitterCoordinate is a Twitter platform object and stores coordinates.
+// TwitterCoordinate is a Twitter platform object and stores the exact location of a tweet.
 type TwitterCoordinate struct {
-	Type        string        `json:"type"`
-	Coordinates []interface{} `json:"coordinates"`
+	Type        string `json:"type"`
+	Coordinates Point  `json:"coordinates"`
+}
+
+// TwitterBoundingBox is a Twitter platform object and stores the area covered by a place.
+type TwitterBoundingBox struct {
+	Type        string  `json:"type"`
+	Coordinates Polygon `json:"coordinates"`
 }
 
 // TwitterPlace is a Twitter platform object for places.
 type TwitterPlace struct {
-	ID              string                 `json:"id"`
-	URL             string                 `json:"url"`
-	PlaceType       string                 `json:"place_type"`
-	Name            string                 `json:"name"`
-	FullName        string                 `json:"full_name"`
-	CountryCode     string                 `json:"country_code"`
-	Country         string                 `json:"country"`
-	BoundingBox     TwitterCoordinate      `json:"bounding_box"`
-	ContainedWithin map[string]interface{} `json:"contained_within"`
+	ID              string             `json:"id"`
+	URL             string             `json:"url"`
+	PlaceType       string             `json:"place_type"`
+	Name            string             `json:"name"`
+	FullName        string             `json:"full_name"`
+	CountryCode     string             `json:"country_code"`
+	Country         string             `json:"country"`
+	BoundingBox     TwitterBoundingBox `json:"bounding_box"`
+	ContainedWithin []TwitterPlace     `json:"contained_within"`
+	Attributes      map[string]string  `json:"attributes"`
 }
 
 // TwitterEntity contains entity information associated to a tweet.
@@ -145,6 +217,8 @@ type TwitterEntity struct {
 	Media        []TweetMedia       `json:"media"`
 	URLs         []TweetURL         `json:"urls"`
 	UserMentions []TweetUserMention `json:"user_mentions"`
+	Symbols      []TweetSymbol      `json:"symbols"`
+	Polls        []TweetPoll        `json:"polls"`
 }
 
 // TweetHashTag contains any hashtags that are found within the tweet.
@@ -153,18 +227,84 @@ type TweetHashTag struct {
 	Indices []uint `json:"indices"`
 }
 
+// TweetSymbol contains any symbols ($cashtags) that are found within the tweet.
+type TweetSymbol struct {
+	Text    string `json:"text"`
+	Indices []uint `json:"indices"`
+}
+
+// TweetPoll contains a poll which is attached to the tweet.
+type TweetPoll struct {
+	Options         []TweetPollOption `json:"options"`
+	EndDatetime     TwitterTime       `json:"end_datetime"`
+	DurationMinutes uint32            `json:"duration_minutes"`
+}
+
+// TweetPollOption is one of the choices of a poll.  Votes is only set when Twitter
+// includes the count (e.g. once the poll has ended).
+type TweetPollOption struct {
+	Position uint   `json:"position"`
+	Text     string `json:"text"`
+	Votes    uint32 `json:"votes"`
+}
+
+// TwitterExtendedEntity contains all media associated to a tweet (entities only holds the first).
+type TwitterExtendedEntity struct {
+	Media []TweetMedia `json:"media"`
+}
+
 // TweetMedia contains any media types that are associated to the tweet.
 type TweetMedia struct {
-	ID             string                 `json:"id_str"`
-	Type           string                 `json:"type"`
-	URL            string                 `json:"url"`
-	DisplayURL     string                 `json:"display_url"`
-	ExpandedURL    string                 `json:"expanded_url"`
-	MediaURL       string                 `json:"media_url"`
-	MediaURLHttps  string                 `json:"media_url_https"`
-	Sizes          map[string]interface{} `json:"sizes"` // https://dev.twitter.com/docs/platform-objects/entities#obj-sizes
-	Indices        []uint                 `json:"indices"`
-	SourceStatusID string                 `json:"source_id_status_str"`
+	ID                  string                   `json:"id_str"`
+	Type                string                   `json:"type"`
+	URL                 string                   `json:"url"`
+	DisplayURL          string                   `json:"display_url"`
+	ExpandedURL         string                   `json:"expanded_url"`
+	MediaURL            string                   `json:"media_url"`
+	MediaURLHttps       string                   `json:"media_url_https"`
+	Sizes               TweetMediaSizes          `json:"sizes"`
+	Indices             []uint                   `json:"indices"`
+	SourceStatusID      string                   `json:"source_status_id_str"`
+	VideoInfo           TweetVideoInfo           `json:"video_info"`
+	AdditionalMediaInfo TweetAdditionalMediaInfo `json:"additional_media_info"`
+}
+
+// TweetMediaSizes contains the available sizes of a media item.
+// https://dev.twitter.com/docs/platform-objects/entities#obj-sizes
+type TweetMediaSizes struct {
+	Thumb  TweetMediaSize `json:"thumb"`
+	Small  TweetMediaSize `json:"small"`
+	Medium TweetMediaSize `json:"medium"`
+	Large  TweetMediaSize `json:"large"`
+}
+
+// TweetMediaSize contains the dimensions of a media size, and how it was resized ("fit" or "crop").
+type TweetMediaSize struct {
+	W      uint32 `json:"w"`
+	H      uint32 `json:"h"`
+	Resize string `json:"resize"`
+}
+
+// TweetVideoInfo contains information about video and animated GIF media.
+type TweetVideoInfo struct {
+	AspectRatio    []uint32            `json:"aspect_ratio"`
+	DurationMillis uint32              `json:"duration_millis"`
+	Variants       []TweetVideoVariant `json:"variants"`
+}
+
+// TweetVideoVariant is one available encoding of a video.
+type TweetVideoVariant struct {
+	Bitrate     uint32 `json:"bitrate"`
+	ContentType string `json:"content_type"`
+	URL         string `json:"url"`
+}
+
+// TweetAdditionalMediaInfo contains extra information about (mostly promoted) video media.
+type TweetAdditionalMediaInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Embeddable  bool   `json:"embeddable"`
+	Monetizable bool   `json:"monetizable"`
 }
 
 // TweetURL contains any URLs that are found within the tweet.
@@ -189,6 +329,13 @@ type Tokener interface {
 	Token(*oauth.Client) (*oauth.Credentials, error)
 }
 
+// A Revoker is a Tokener which can discard a user token that Twitter no longer accepts
+// (e.g. access to the app was revoked by the user).  When a request receives a 401,
+// Revoke is called and Token is then used to re-authorize the user.
+type Revoker interface {
+	Revoke(*oauth.Credentials) error
+}
+
 // NewClient creates a new StreamClient for access to the Twitter API (both stream & rest).
 func NewClient() (client *StreamClient) {
 	client = new(StreamClient)
@@ -197,6 +344,10 @@ func NewClient() (client *StreamClient) {
 		ResourceOwnerAuthorizationURI: "https://api.twitter.com/oauth/authorize",
 		TokenRequestURI:               "https://api.twitter.com/oauth/access_token",
 	}
+	client.httpClient = http.DefaultClient
+	client.activity = new(streamActivities)
+	client.metrics = newStreamMetrics()
+	client.queueStats = new(QueueStats)
 	client.Errors = make(chan error)
 	client.Finished = make(chan struct{})
 	return
@@ -204,33 +355,141 @@ func NewClient() (client *StreamClient) {
 
 // Authenicate the app and user, with Twitter using the oauth client.
 func (s *StreamClient) Authenticate(t Tokener) (err error) {
-	s.token, err = t.Token(s.oauthClient)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokener = t
+	if s.token, err = t.Token(s.oauthClient); err != nil {
+		logEvent(s.Logger, slog.LevelError, "authentication failed", "error", err)
+		return
+	}
+	logEvent(s.Logger, slog.LevelInfo, "authenticated")
 	return
 }
 
-// Send a request to Twitter.
+// AuthenticateAndVerify authenticates the app and user, and then checks with Twitter that
+// the user's token is valid.  The authenticated user is returned.
+func (s *StreamClient) AuthenticateAndVerify(t Tokener) (*TwitterUser, error) {
+	if err := s.Authenticate(t); err != nil {
+		return nil, err
+	}
+	return s.VerifyCredentials()
+}
+
+// VerifyCredentials returns the authenticated user, or an error if the user's token is
+// not accepted by Twitter.
+func (s *StreamClient) VerifyCredentials() (*TwitterUser, error) {
+	resp, err := s.sendRequest(verifyCredentialsURL, &url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	user := new(TwitterUser)
+	if err := json.NewDecoder(resp.Body).Decode(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Send a request to Twitter.  If the user's token is rejected and the Tokener is a
+// Revoker, the user is re-authorized and the request is tried again.
 // Calling method is responsible for closing the connection.
 func (s *StreamClient) sendRequest(stream *TwitterAPIURL, formValues *url.Values) (*http.Response, error) {
-	var method func(*http.Client, *oauth.Credentials, string, url.Values) (*http.Response, error)
-	if stream.AccessMethod == "custom" {
-		method = stream.CustomHandler
-	} else {
-		if stream.AccessMethod == "post" {
-			method = s.oauthClient.Post
-		} else {
-			method = s.oauthClient.Get
+	return s.sendRequestBody(stream, formValues, nil)
+}
+
+// Send a request with a json or multipart body to Twitter (see sendRequest).
+func (s *StreamClient) sendRequestBody(stream *TwitterAPIURL, formValues *url.Values, body interface{}) (*http.Response, error) {
+	token := s.currentToken()
+	resp, err := s.request(token, stream, formValues, body)
+	if terr, ok := err.(*TwitterError); ok && terr.ID == 401 {
+		if reauthorized, rerr := s.reauthorize(token); rerr != nil {
+			return nil, rerr
+		} else if reauthorized {
+			return s.request(s.currentToken(), stream, formValues, body)
 		}
 	}
+	return resp, err
+}
+
+// Returns the user token requests are currently signed with.
+func (s *StreamClient) currentToken() *oauth.Credentials {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// Discards the rejected user token and requests a new one.  Returns false if the
+// Tokener doesn't support revoking tokens, or Twitter still accepts the token (401s are
+// also returned when the clock is skewed).  If the token has already been replaced, by
+// another request rejected at the same time, the new token is used without revoking it.
+func (s *StreamClient) reauthorize(rejected *oauth.Credentials) (bool, error) {
+	s.mu.Lock()
+	tokener := s.tokener
+	s.mu.Unlock()
+	r, ok := tokener.(Revoker)
+	if !ok {
+		return false, nil
+	}
+
+	resp, err := s.request(rejected, verifyCredentialsURL, &url.Values{}, nil)
+	if err == nil {
+		resp.Body.Close()
+		logEvent(s.Logger, slog.LevelWarn, "request rejected but user token verified, not re-authorizing")
+		return false, nil
+	} else if terr, ok := err.(*TwitterError); !ok || terr.ID != 401 {
+		logEvent(s.Logger, slog.LevelWarn, "user token not verified, not re-authorizing", "error", err)
+		return false, nil
+	}
+
+	s.reauthorizing.Lock()
+	defer s.reauthorizing.Unlock()
+	if s.currentToken() != rejected {
+		return true, nil
+	}
+	logEvent(s.Logger, slog.LevelWarn, "user token rejected, re-authorizing")
+	if err := r.Revoke(rejected); err != nil {
+		return true, err
+	}
 
-	resp, err := method(http.DefaultClient, s.token, stream.URL, *formValues)
+	token, err := tokener.Token(s.oauthClient)
 	if err != nil {
+		return true, err
+	}
+	s.mu.Lock()
+	s.token = token
+	s.mu.Unlock()
+	return true, nil
+}
+
+// Send a single request to Twitter signed with token, converting error responses to a
+// TwitterError.
+func (s *StreamClient) request(token *oauth.Credentials, stream *TwitterAPIURL, formValues *url.Values, body interface{}) (*http.Response, error) {
+	s.mu.Lock()
+	bearer, httpClient := s.bearer, s.httpClient
+	s.mu.Unlock()
+
+	logEvent(s.Logger, slog.LevelDebug, "sending request", "method", stream.AccessMethod, "url", stream.URL)
+	var resp *http.Response
+	var err error
+	if stream.AccessMethod == "custom" {
+		resp, err = stream.CustomHandler(httpClient, token, stream.URL, *formValues)
+	} else {
+		resp, err = s.do(httpClient, token, bearer, stream, *formValues, body)
+	}
+	if err != nil {
+		logEvent(s.Logger, slog.LevelError, "request failed", "url", stream.URL, "error", err)
 		return nil, err
 	}
+	if resp.StatusCode >= 400 {
+		logEvent(s.Logger, slog.LevelWarn, "request rejected", "url", stream.URL, "status", resp.StatusCode)
+	} else {
+		logEvent(s.Logger, slog.LevelDebug, "response received", "url", stream.URL, "status", resp.StatusCode)
+	}
 
 	// https://dev.twitter.com/docs/streaming-api-response-codes
 	switch resp.StatusCode {
 	case 401:
-		// Delete User entry in tokens json file?
 		return nil, &TwitterError{
 			ID:  resp.StatusCode,
 			Msg: "Incorrect usename or password.",
@@ -266,8 +525,93 @@ func (s *StreamClient) sendRequest(stream *TwitterAPIURL, formValues *url.Values
 			Msg: "Rate limited.",
 		}
 	default:
-		return resp, nil
+		return decompress(resp), nil
+	}
+}
+
+// Unmarshal a status from Twitter, keeping a copy of the raw message.
+func (ts *TwitterStatus) UnmarshalJSON(b []byte) error {
+	type status TwitterStatus
+	if err := json.Unmarshal(b, (*status)(ts)); err != nil {
+		return err
+	}
+	ts.raw = append(json.RawMessage(nil), b...)
+	return nil
+}
+
+// Raw returns the JSON message exactly as it was received from Twitter.
+func (ts *TwitterStatus) Raw() json.RawMessage {
+	return ts.raw
+}
+
+// Extra returns the top level fields of the raw message which are not modelled by
+// TwitterStatus (contributors, scopes, filter_level, timestamp_ms etc.)
+func (ts *TwitterStatus) Extra() (map[string]json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage)
+	if len(ts.raw) == 0 {
+		return fields, nil
+	}
+	if err := json.Unmarshal(ts.raw, &fields); err != nil {
+		return nil, err
+	}
+	known := jsonFieldNames(reflect.TypeOf(*ts))
+	for k := range fields {
+		if known[strings.ToLower(k)] {
+			delete(fields, k)
+		}
+	}
+	return fields, nil
+}
+
+// Returns the (lower cased) JSON field names of struct type t.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names[strings.ToLower(name)] = true
 	}
+	return names
+}
+
+// Media returns all media associated to the tweet, preferring extended_entities over entities.
+func (ts *TwitterStatus) Media() []TweetMedia {
+	if len(ts.ExtendedEntities.Media) > 0 {
+		return ts.ExtendedEntities.Media
+	}
+	return ts.Entities.Media
+}
+
+// BestVariant returns the highest bitrate MP4 variant of a video which fits within
+// maxBitrate (bits per second).  If none fit, the lowest bitrate MP4 variant is returned.
+// ok is false when the media has no MP4 variants.
+func (m *TweetMedia) BestVariant(maxBitrate uint32) (best TweetVideoVariant, ok bool) {
+	var lowest TweetVideoVariant
+	for _, v := range m.VideoInfo.Variants {
+		if v.ContentType != "video/mp4" {
+			continue
+		}
+		if !ok || v.Bitrate < lowest.Bitrate {
+			lowest = v
+		}
+		if v.Bitrate <= maxBitrate && (best.URL == "" || v.Bitrate > best.Bitrate) {
+			best = v
+		}
+		ok = true
+	}
+	if best.URL == "" {
+		best = lowest
+	}
+	return
 }
 
 // Unmarshal a timestamp from Twitter
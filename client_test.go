@@ -3,11 +3,17 @@
 package streamingtwitter
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"github.com/garyburd/go-oauth/oauth"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestTwitterErrorOutput(t *testing.T) {
@@ -83,3 +89,308 @@ func TestSendResponseErrorOutput(t *testing.T) {
 		}
 	}
 }
+
+func TestVideoMediaDecode(t *testing.T) {
+	data := `{
+		"id_str": "1",
+		"entities": {"media": [{"id_str": "10", "type": "video"}]},
+		"extended_entities": {"media": [{
+			"id_str": "10",
+			"type": "video",
+			"sizes": {"large": {"w": 1280, "h": 720, "resize": "fit"}},
+			"video_info": {
+				"aspect_ratio": [16, 9],
+				"duration_millis": 30033,
+				"variants": [
+					{"content_type": "application/x-mpegURL", "url": "https://video.twimg.com/pl.m3u8"},
+					{"bitrate": 832000, "content_type": "video/mp4", "url": "https://video.twimg.com/640x360.mp4"},
+					{"bitrate": 2176000, "content_type": "video/mp4", "url": "https://video.twimg.com/1280x720.mp4"},
+					{"bitrate": 320000, "content_type": "video/mp4", "url": "https://video.twimg.com/320x180.mp4"}
+				]
+			},
+			"additional_media_info": {"title": "Title", "monetizable": true}
+		}]}
+	}`
+
+	status := &TwitterStatus{}
+	if err := json.Unmarshal([]byte(data), status); err != nil {
+		t.Fatal(err)
+	}
+
+	media := status.Media()
+	if len(media) != 1 {
+		t.Fatalf("Expecting 1 media item, got %v", len(media))
+	}
+	m := media[0]
+
+	testData := []JSONTestData{
+		{"Sizes.Large.W", m.Sizes.Large.W, uint32(1280)},
+		{"Sizes.Large.H", m.Sizes.Large.H, uint32(720)},
+		{"VideoInfo.AspectRatio[0]", m.VideoInfo.AspectRatio[0], uint32(16)},
+		{"VideoInfo.DurationMillis", m.VideoInfo.DurationMillis, uint32(30033)},
+		{"VideoInfo.Variants[1].Bitrate", m.VideoInfo.Variants[1].Bitrate, uint32(832000)},
+		{"AdditionalMediaInfo.Title", m.AdditionalMediaInfo.Title, "Title"},
+		{"AdditionalMediaInfo.Monetizable", m.AdditionalMediaInfo.Monetizable, true},
+	}
+	for _, d := range testData {
+		if d.v != d.e {
+			t.Errorf("%v: expecting %v, got %v", d.n, d.e, d.v)
+		}
+	}
+
+	budgets := []struct {
+		b uint32
+		e string
+	}{
+		{5000000, "https://video.twimg.com/1280x720.mp4"},
+		{1000000, "https://video.twimg.com/640x360.mp4"},
+		{100000, "https://video.twimg.com/320x180.mp4"},
+	}
+	for _, d := range budgets {
+		if v, ok := m.BestVariant(d.b); !ok || v.URL != d.e {
+			t.Errorf("BestVariant(%v): expecting %v, got %v", d.b, d.e, v.URL)
+		}
+	}
+
+	if _, ok := status.Entities.Media[0].BestVariant(5000000); ok {
+		t.Error("Not expecting a variant for media without video info")
+	}
+}
+
+func TestSymbolsAndPollsDecode(t *testing.T) {
+	data := `{
+		"symbols": [{"text": "TWTR", "indices": [10, 15]}],
+		"polls": [{
+			"options": [{"position": 1, "text": "Buy", "votes": 12}, {"position": 2, "text": "Sell", "votes": 3}],
+			"end_datetime": "Thu May 25 22:20:27 +0000 2017",
+			"duration_minutes": 60
+		}]
+	}`
+
+	entity := &TwitterEntity{}
+	if err := json.Unmarshal([]byte(data), entity); err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []JSONTestData{
+		{"Symbols[0].Text", entity.Symbols[0].Text, "TWTR"},
+		{"Symbols[0].Indices[1]", entity.Symbols[0].Indices[1], uint(15)},
+		{"Polls[0].Options[1].Position", entity.Polls[0].Options[1].Position, uint(2)},
+		{"Polls[0].Options[1].Text", entity.Polls[0].Options[1].Text, "Sell"},
+		{"Polls[0].Options[0].Votes", entity.Polls[0].Options[0].Votes, uint32(12)},
+		{"Polls[0].EndDatetime", entity.Polls[0].EndDatetime.T.String(), "2017-05-25 22:20:27 +0000 UTC"},
+		{"Polls[0].DurationMinutes", entity.Polls[0].DurationMinutes, uint32(60)},
+	}
+	for _, d := range testData {
+		if d.v != d.e {
+			t.Errorf("%v: expecting %v, got %v", d.n, d.e, d.v)
+		}
+	}
+}
+
+// Tokener which hands out a new token each time it is asked for one.
+type revokingTokens struct {
+	mu      sync.Mutex
+	issued  int
+	revoked []*oauth.Credentials
+}
+
+func (r *revokingTokens) Token(*oauth.Client) (*oauth.Credentials, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.issued++
+	return &oauth.Credentials{Token: fmt.Sprintf("user-token-%d", r.issued)}, nil
+}
+
+func (r *revokingTokens) Revoke(token *oauth.Credentials) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revoked = append(r.revoked, token)
+	return nil
+}
+
+// Returns a custom URL which rejects user-token-1, as verify_credentials would once it
+// has been revoked.
+func rejectingURL() *TwitterAPIURL {
+	return &TwitterAPIURL{
+		AccessMethod: "custom",
+		CustomHandler: func(_ *http.Client, token *oauth.Credentials, _ string, _ url.Values) (*http.Response, error) {
+			if token.Token == "user-token-1" {
+				return &http.Response{StatusCode: 401}, nil
+			}
+			return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewBufferString("{}"))}, nil
+		},
+	}
+}
+
+func TestAuthenticateAndVerify(t *testing.T) {
+	defer func(u *TwitterAPIURL) { verifyCredentialsURL = u }(verifyCredentialsURL)
+	verifyCredentialsURL = &TwitterAPIURL{
+		AccessMethod: "custom",
+		CustomHandler: func(_ *http.Client, token *oauth.Credentials, _ string, _ url.Values) (*http.Response, error) {
+			if token.Token != "user-token" {
+				return &http.Response{StatusCode: 401}, nil
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"id_str":"123","screen_name":"gopher"}`)),
+			}, nil
+		},
+	}
+
+	client := NewClient()
+	user, err := client.AuthenticateAndVerify(&StaticTokens{
+		App:  testAppToken,
+		User: &oauth.Credentials{Token: "user-token", Secret: "user-secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != "123" || user.ScreenName != "gopher" {
+		t.Errorf("Unexpected user %+v", user)
+	}
+
+	_, err = client.AuthenticateAndVerify(&StaticTokens{
+		App:  testAppToken,
+		User: &oauth.Credentials{Token: "bad-token", Secret: "user-secret"},
+	})
+	if rerr, ok := err.(*TwitterError); !ok || rerr.ID != 401 {
+		t.Errorf("Expecting TwitterError 401, got %v", err)
+	}
+}
+
+func TestReauthorizeOn401(t *testing.T) {
+	defer func(u *TwitterAPIURL) { verifyCredentialsURL = u }(verifyCredentialsURL)
+	verifyCredentialsURL = rejectingURL()
+
+	tokens := &revokingTokens{}
+	client := NewClient()
+	client.Authenticate(tokens)
+
+	if _, err := client.sendRequest(rejectingURL(), &url.Values{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens.revoked) != 1 || tokens.revoked[0].Token != "user-token-1" {
+		t.Errorf("Expecting user-token-1 to be revoked, got %v", tokens.revoked)
+	}
+	if client.token.Token != "user-token-2" {
+		t.Errorf("Expecting client to use user-token-2, got %v", client.token.Token)
+	}
+}
+
+func TestReauthorizeConcurrent401s(t *testing.T) {
+	defer func(u *TwitterAPIURL) { verifyCredentialsURL = u }(verifyCredentialsURL)
+	verifyCredentialsURL = rejectingURL()
+
+	tokens := &revokingTokens{}
+	client := NewClient()
+	client.Authenticate(tokens)
+
+	// Both requests are rejected before either re-authorizes
+	var rejected sync.WaitGroup
+	rejected.Add(2)
+	testurl := &TwitterAPIURL{
+		AccessMethod: "custom",
+		CustomHandler: func(c *http.Client, token *oauth.Credentials, u string, v url.Values) (*http.Response, error) {
+			if token.Token == "user-token-1" {
+				rejected.Done()
+				rejected.Wait()
+			}
+			return rejectingURL().CustomHandler(c, token, u, v)
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.sendRequest(testurl, &url.Values{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(tokens.revoked) != 1 || tokens.issued != 2 {
+		t.Errorf("Expecting 1 re-authorization, got %v revoked and %v issued", tokens.revoked, tokens.issued)
+	}
+}
+
+// Tokener which, once waiting is set, waits for release before handing out a new token
+// (as one asking the user would).
+type waitingTokens struct {
+	revokingTokens
+	waiting chan struct{}
+	release chan struct{}
+}
+
+func (w *waitingTokens) Token(oc *oauth.Client) (*oauth.Credentials, error) {
+	if w.waiting != nil {
+		close(w.waiting)
+		<-w.release
+	}
+	return w.revokingTokens.Token(oc)
+}
+
+func TestReauthorizeDoesNotBlockClient(t *testing.T) {
+	defer func(u *TwitterAPIURL) { verifyCredentialsURL = u }(verifyCredentialsURL)
+	verifyCredentialsURL = rejectingURL()
+
+	tokens := &waitingTokens{}
+	client := NewClient()
+	client.Authenticate(tokens)
+	tokens.waiting = make(chan struct{})
+	tokens.release = make(chan struct{})
+
+	requested := make(chan error)
+	go func() {
+		_, err := client.sendRequest(rejectingURL(), &url.Values{})
+		requested <- err
+	}()
+	<-tokens.waiting
+
+	// The client stays usable while the user is asked to authorize it again
+	done := make(chan struct{})
+	go func() {
+		client.CloseStreams()
+		client.currentToken()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Client blocked while re-authorizing")
+	}
+
+	close(tokens.release)
+	if err := <-requested; err != nil {
+		t.Fatal(err)
+	}
+	if client.currentToken().Token != "user-token-2" {
+		t.Errorf("Expecting client to use user-token-2, got %v", client.currentToken().Token)
+	}
+}
+
+func TestReauthorizeSkipsVerifiedToken(t *testing.T) {
+	defer func(u *TwitterAPIURL) { verifyCredentialsURL = u }(verifyCredentialsURL)
+	verifyCredentialsURL = &TwitterAPIURL{
+		AccessMethod: "custom",
+		CustomHandler: func(*http.Client, *oauth.Credentials, string, url.Values) (*http.Response, error) {
+			return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewBufferString("{}"))}, nil
+		},
+	}
+
+	tokens := &revokingTokens{}
+	client := NewClient()
+	client.Authenticate(tokens)
+
+	// Rejected for some other reason than the token, e.g. clock skew
+	_, err := client.sendRequest(rejectingURL(), &url.Values{})
+	if rerr, ok := err.(*TwitterError); !ok || rerr.ID != 401 {
+		t.Errorf("Expecting TwitterError 401, got %v", err)
+	}
+	if len(tokens.revoked) != 0 || client.token.Token != "user-token-1" {
+		t.Errorf("Expecting user-token-1 to be kept, got %v revoked", tokens.revoked)
+	}
+}
@@ -31,6 +31,7 @@ var (
 
 	// Flag parsing variables
 	tokenFile     string
+	profile       string
 	stream        string
 	followUsers   string
 	location      string
@@ -41,6 +42,7 @@ var (
 
 func init() {
 	flag.StringVar(&tokenFile, "config", "../tokens.json", "Token storage file location")
+	flag.StringVar(&profile, "profile", "", "Named user profile within the token file")
 	flag.StringVar(&stream, "stream", "Filter", "Type of stream to open: <Filter>, <Firehose>, <Sample>")
 	flag.StringVar(&followUsers, "follow", "", "Twitter users to track seperated by commas")
 	flag.StringVar(&trackKeywords, "track", "", "Keywords to track seperated by commas")
@@ -89,6 +91,7 @@ func main() {
 
 		err := client.Authenticate(&streamingtwitter.ClientTokens{
 			TokenFile: tokenFile,
+			Profile:   profile,
 		})
 		if err != nil {
 			log.Fatal(err)
@@ -98,6 +101,10 @@ func main() {
 	// Define arguments to pass to the stream.  (Only required Filter stream options are supported currently)
 	// https://dev.twitter.com/docs/streaming-apis/parameters
 	args := &url.Values{}
+	// Twitter matches locations on place overlap, so tweets are re-checked against these.
+	// Tweets match any of follow, track and locations, so only when locations is the only
+	// filter (otherwise boxes is left nil).
+	var boxes []streamingtwitter.LocationBox
 	if stream == "Filter" {
 		// At least one of: follow,locations or track must be specified to use the filter stream
 		if followUsers == "" && trackKeywords == "" && location == "" {
@@ -146,6 +153,15 @@ func main() {
 			args.Add("track", trackKeywords)
 		}
 		if location != "" {
+			parsed, err := streamingtwitter.ParseLocations(location)
+			if err != nil {
+				ticker.Stop()
+				clearScreen()
+				log.Fatal(err)
+			}
+			if followUsers == "" && trackKeywords == "" {
+				boxes = parsed
+			}
 			args.Add("locations", location)
 		}
 	} else {
@@ -218,6 +234,10 @@ func main() {
 	for {
 		select {
 		case status := <-tweets:
+			if boxes != nil && !status.WithinLocations(boxes) {
+				continue
+			}
+
 			if loaded == false {
 				loaded = true
 				ticker.Stop()
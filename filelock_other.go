@@ -0,0 +1,12 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package streamingtwitter
+
+// Advisory file locks are not supported on this platform, token file writes are still
+// atomic but concurrent processes may overwrite each other's changes.
+func lockFile(name string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,28 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package streamingtwitter
+
+import (
+	"os"
+	"syscall"
+)
+
+// Takes an exclusive advisory lock on name (creating it if needed), blocking until the
+// lock is available.  Call the returned function to release it.
+func lockFile(name string) (func(), error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, tokenFilePermission)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,178 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Mean radius of the Earth in kilometres
+	earthRadius = 6371.0088
+)
+
+// Point is a GeoJSON position.  Twitter (and GeoJSON) orders it as longitude, latitude.
+type Point struct {
+	Lng float64
+	Lat float64
+}
+
+// Polygon is a GeoJSON polygon.  The first ring is the outer boundary, any following
+// rings are holes within it.
+type Polygon [][]Point
+
+// LocationBox is a bounding box as used by the "locations" parameter of the Filter stream.
+type LocationBox struct {
+	SouthWest Point
+	NorthEast Point
+}
+
+// Unmarshal a [longitude, latitude] position from Twitter
+func (p *Point) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	var pos []float64
+	if err := json.Unmarshal(b, &pos); err != nil {
+		return err
+	}
+	if len(pos) < 2 {
+		return fmt.Errorf("invalid position %s", b)
+	}
+	p.Lng, p.Lat = pos[0], pos[1]
+	return nil
+}
+
+// MarshalJSON writes the point back out as a [longitude, latitude] position.
+func (p Point) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]float64{p.Lng, p.Lat})
+}
+
+// Distance returns the great-circle distance in kilometres between p and q.
+func (p Point) Distance(q Point) float64 {
+	lat1, lat2 := p.Lat*math.Pi/180, q.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (q.Lng - p.Lng) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Contains reports whether pt lies within the polygon (and not within one of its holes).
+func (p Polygon) Contains(pt Point) bool {
+	if len(p) == 0 || !ringContains(p[0], pt) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if ringContains(hole, pt) {
+			return false
+		}
+	}
+	return true
+}
+
+// Centroid returns the mean of the outer ring's vertices.  This is exact for the
+// rectangular bounding boxes Twitter sends with places.
+func (p Polygon) Centroid() (pt Point) {
+	if len(p) == 0 || len(p[0]) == 0 {
+		return
+	}
+	ring := p[0]
+	// Closed rings repeat their first vertex at the end
+	if len(ring) > 1 && ring[0] == ring[len(ring)-1] {
+		ring = ring[:len(ring)-1]
+	}
+	for _, v := range ring {
+		pt.Lng += v.Lng
+		pt.Lat += v.Lat
+	}
+	pt.Lng /= float64(len(ring))
+	pt.Lat /= float64(len(ring))
+	return
+}
+
+// Ray casting test of pt against a single ring.
+func ringContains(ring []Point, pt Point) bool {
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > pt.Lat) != (b.Lat > pt.Lat) &&
+			pt.Lng < (b.Lng-a.Lng)*(pt.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lng {
+			in = !in
+		}
+	}
+	return in
+}
+
+// Contains reports whether pt lies within the box (edges included).
+func (lb LocationBox) Contains(pt Point) bool {
+	return pt.Lng >= lb.SouthWest.Lng && pt.Lng <= lb.NorthEast.Lng &&
+		pt.Lat >= lb.SouthWest.Lat && pt.Lat <= lb.NorthEast.Lat
+}
+
+// String formats the box as expected by the "locations" stream parameter.
+func (lb LocationBox) String() string {
+	return fmt.Sprintf("%s,%s,%s,%s",
+		strconv.FormatFloat(lb.SouthWest.Lng, 'f', -1, 64), strconv.FormatFloat(lb.SouthWest.Lat, 'f', -1, 64),
+		strconv.FormatFloat(lb.NorthEast.Lng, 'f', -1, 64), strconv.FormatFloat(lb.NorthEast.Lat, 'f', -1, 64))
+}
+
+// ParseLocations parses the value of a "locations" stream parameter (comma seperated
+// south-west and north-east longitude, latitude pairs) into bounding boxes.
+func ParseLocations(s string) ([]LocationBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts)%4 != 0 {
+		return nil, fmt.Errorf("locations must be given as sets of 4 values, got %d", len(parts))
+	}
+
+	values := make([]float64, len(parts))
+	for i, v := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = f
+	}
+
+	boxes := make([]LocationBox, 0, len(values)/4)
+	for i := 0; i < len(values); i += 4 {
+		boxes = append(boxes, LocationBox{
+			SouthWest: Point{values[i], values[i+1]},
+			NorthEast: Point{values[i+2], values[i+3]},
+		})
+	}
+	return boxes, nil
+}
+
+// Location returns the tweet's exact coordinates, falling back to the centroid of
+// the place's bounding box.  ok is false when the tweet has neither.
+func (ts *TwitterStatus) Location() (pt Point, ok bool) {
+	if ts.Coordinates.Type == "Point" {
+		return ts.Coordinates.Coordinates, true
+	}
+	if len(ts.Place.BoundingBox.Coordinates) > 0 {
+		return ts.Place.BoundingBox.Coordinates.Centroid(), true
+	}
+	return
+}
+
+// WithinLocations reports whether the tweet's location (see Location()) is inside any
+// of the boxes.  Twitter matches the Filter stream's locations on place overlap, so this
+// can be used to re-check tweets which should strictly lie within the boxes.
+func (ts *TwitterStatus) WithinLocations(boxes []LocationBox) bool {
+	pt, ok := ts.Location()
+	if !ok {
+		return false
+	}
+	for _, b := range boxes {
+		if b.Contains(pt) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+var newYork = Polygon{{{-74.04725, 40.541722}, {-74.04725, 40.91533}, {-73.699793, 40.91533}, {-73.699793, 40.541722}}}
+
+func TestPointJSON(t *testing.T) {
+	p := Point{}
+	if err := json.Unmarshal([]byte("[-74.210251, 40.422551]"), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Lng != -74.210251 || p.Lat != 40.422551 {
+		t.Errorf("Expecting (-74.210251, 40.422551), got %v", p)
+	}
+
+	b, _ := json.Marshal(p)
+	if string(b) != "[-74.210251,40.422551]" {
+		t.Errorf("Expecting [-74.210251,40.422551], got %s", b)
+	}
+
+	if err := json.Unmarshal([]byte("[1]"), &p); err == nil {
+		t.Error("Expecting error for an incomplete position")
+	}
+}
+
+func TestPolygonContains(t *testing.T) {
+	testData := []struct {
+		p Point
+		e bool
+	}{
+		{Point{-73.9, 40.7}, true},
+		{Point{-74.2, 40.4}, false},
+		{Point{-73.9, 41.0}, false},
+	}
+	for _, d := range testData {
+		if newYork.Contains(d.p) != d.e {
+			t.Errorf("Contains(%v): expecting %v", d.p, d.e)
+		}
+	}
+
+	holed := Polygon{newYork[0], {{-74, 40.6}, {-74, 40.8}, {-73.8, 40.8}, {-73.8, 40.6}}}
+	if holed.Contains(Point{-73.9, 40.7}) {
+		t.Error("Point within a hole should not be contained")
+	}
+}
+
+func TestPolygonCentroid(t *testing.T) {
+	c := newYork.Centroid()
+	if math.Abs(c.Lng-(-73.8735215)) > 1e-9 || math.Abs(c.Lat-40.728526) > 1e-9 {
+		t.Errorf("Unexpected centroid %v", c)
+	}
+}
+
+func TestPointDistance(t *testing.T) {
+	oslo := Point{10.7522, 59.9139}
+	bergen := Point{5.3221, 60.3913}
+	if d := oslo.Distance(bergen); d < 300 || d > 310 {
+		t.Errorf("Expecting distance of ~305km, got %v", d)
+	}
+}
+
+func TestParseLocations(t *testing.T) {
+	boxes, err := ParseLocations("-122.75,36.8,-121.75,37.8,-74,40,-73,41")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(boxes) != 2 {
+		t.Fatalf("Expecting 2 boxes, got %v", len(boxes))
+	}
+	if boxes[1].String() != "-74,40,-73,41" {
+		t.Errorf("Expecting \"-74,40,-73,41\", got %v", boxes[1])
+	}
+
+	if _, err := ParseLocations("1,2,3"); err == nil {
+		t.Error("Expecting error for incomplete locations")
+	}
+	if _, err := ParseLocations("1,2,3,x"); err == nil {
+		t.Error("Expecting error for invalid locations")
+	}
+}
+
+func TestStatusLocation(t *testing.T) {
+	status := &TwitterStatus{}
+	if _, ok := status.Location(); ok {
+		t.Error("Not expecting a location")
+	}
+
+	status.Place.BoundingBox.Coordinates = newYork
+	if pt, _ := status.Location(); pt != newYork.Centroid() {
+		t.Errorf("Expecting place centroid, got %v", pt)
+	}
+
+	status.Coordinates = TwitterCoordinate{Type: "Point", Coordinates: Point{-74.210251, 40.422551}}
+	if pt, _ := status.Location(); pt != status.Coordinates.Coordinates {
+		t.Errorf("Expecting exact coordinates, got %v", pt)
+	}
+
+	boxes, _ := ParseLocations("-74.3,40.4,-74.1,40.5")
+	if !status.WithinLocations(boxes) {
+		t.Error("Expecting status to be within locations")
+	}
+	status.Coordinates = TwitterCoordinate{}
+	if status.WithinLocations(boxes) {
+		t.Error("Not expecting status to be within locations")
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+)
+
+// A response body which is decompressed as it is read.  Each message is available as soon
+// as Twitter flushes it, rather than when a full block of compressed data has arrived.
+type gzipBody struct {
+	body io.ReadCloser
+	// Created on the first read, so a stream's request isn't held up waiting for the gzip header
+	gz  *gzip.Reader
+	err error
+}
+
+// Wraps the body of a gzip encoded response to decompress it.  This is only needed when
+// gzip was requested explicitly, otherwise the transport decompresses the response itself.
+func decompress(resp *http.Response) *http.Response {
+	if resp.Header.Get("Content-Encoding") == "gzip" && !resp.Uncompressed {
+		resp.Body = &gzipBody{body: resp.Body}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	}
+	return resp
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.gz == nil {
+		if b.err == nil {
+			b.gz, b.err = gzip.NewReader(b.body)
+		}
+		if b.err != nil {
+			return 0, b.err
+		}
+	}
+	return b.gz.Read(p)
+}
+
+func (b *gzipBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,137 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/garyburd/go-oauth/oauth"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Returns test_data/tweet.json as a single stream message.
+func tweetMessage(t *testing.T) []byte {
+	msg := &bytes.Buffer{}
+	if err := json.Compact(msg, readTestTweet(t)); err != nil {
+		t.Fatal(err)
+	}
+	msg.WriteString("\r\n")
+	return msg.Bytes()
+}
+
+func TestGzipStreamRequested(t *testing.T) {
+	fixture := &bytes.Buffer{}
+	gz := gzip.NewWriter(fixture)
+	gz.Write(bytes.Repeat(tweetMessage(t), 3))
+	gz.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(fixture.Bytes())
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.Authenticate(&StaticTokens{App: testAppToken, User: testAppToken})
+	client.Gzip = true
+	tweets := make(chan *TwitterStatus)
+	go client.Stream(tweets, &TwitterAPIURL{URL: ts.URL}, &url.Values{})
+
+	received := 0
+	for {
+		select {
+		case status := <-tweets:
+			if status.ID != "468728009768579073" {
+				t.Errorf("Unexpected status %v", status.ID)
+			}
+			received++
+		case err := <-client.Errors:
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+		case <-client.Finished:
+			if received != 3 {
+				t.Errorf("Expecting 3 statuses, got %v", received)
+			}
+			return
+		case <-time.After(time.Second):
+			t.Fatal("Stream timeout")
+		}
+	}
+}
+
+func TestGzipStreamFlushesPartialFrames(t *testing.T) {
+	pr, pw := io.Pipe()
+	gz := gzip.NewWriter(pw)
+	handler := func(*http.Client, *oauth.Credentials, string, url.Values) (*http.Response, error) {
+		return &http.Response{
+			Header: http.Header{"Content-Encoding": {"gzip"}},
+			Body:   pr,
+		}, nil
+	}
+	testurl := &TwitterAPIURL{
+		AccessMethod:  "custom",
+		CustomHandler: handler,
+	}
+
+	client := NewClient()
+	tweets := make(chan *TwitterStatus)
+	go client.Stream(tweets, testurl, &url.Values{})
+
+	// Written from another goroutine as the pipe blocks until it is read
+	msg := tweetMessage(t)
+	chunks := make(chan []byte)
+	go func() {
+		for b := range chunks {
+			gz.Write(b)
+			gz.Flush()
+		}
+		gz.Close()
+		pw.Close()
+	}()
+	write := func(b []byte) {
+		chunks <- b
+	}
+	expect := func(n string, received bool) {
+		select {
+		case <-tweets:
+			if !received {
+				t.Fatalf("%v: status received early", n)
+			}
+		case err := <-client.Errors:
+			t.Fatalf("%v: %v", n, err)
+		case <-time.After(50 * time.Millisecond):
+			if received {
+				t.Fatalf("%v: status held back", n)
+			}
+		}
+	}
+
+	write(msg)
+	expect("whole message", true)
+	write(msg[:len(msg)/2])
+	expect("partial message", false)
+	write(msg[len(msg)/2:])
+	expect("rest of message", true)
+
+	close(chunks)
+	for {
+		select {
+		case <-client.Errors:
+		case <-client.Finished:
+			return
+		case <-time.After(time.Second):
+			t.Fatal("Stream timeout")
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"context"
+	"log/slog"
+)
+
+// A Logger receives leveled, structured events (connects, disconnects, response statuses,
+// decode errors, skipped messages etc.)  args are alternating keys and values.  Its method
+// matches (*slog.Logger).Log, so a *slog.Logger can be used directly.  Nothing is logged
+// when no Logger is set.
+type Logger interface {
+	Log(ctx context.Context, level slog.Level, msg string, args ...interface{})
+}
+
+// LoggerFunc allows an ordinary function to be used as a Logger.
+type LoggerFunc func(ctx context.Context, level slog.Level, msg string, args ...interface{})
+
+// Log calls f(ctx, level, msg, args...).
+func (f LoggerFunc) Log(ctx context.Context, level slog.Level, msg string, args ...interface{}) {
+	f(ctx, level, msg, args...)
+}
+
+// Sends an event to l, unless it is nil.
+func logEvent(l Logger, level slog.Level, msg string, args ...interface{}) {
+	if l != nil {
+		l.Log(context.Background(), level, msg, args...)
+	}
+}
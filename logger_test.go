@@ -0,0 +1,78 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"bytes"
+	"context"
+	"github.com/garyburd/go-oauth/oauth"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// *slog.Logger must be usable as a Logger
+var _ Logger = slog.Default()
+
+func TestStreamLogsToSlog(t *testing.T) {
+	handler := func(*http.Client, *oauth.Credentials, string, url.Values) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString("{\"id_str\":\"1\"}\r\n{\"id_str\":\"2\",\"text\":1}\r\n")),
+		}, nil
+	}
+	testurl := &TwitterAPIURL{
+		AccessMethod:  "custom",
+		CustomHandler: handler,
+		URL:           "https://stream.example.com",
+	}
+
+	out := &bytes.Buffer{}
+	client := NewClient()
+	client.Logger = slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	tweets := make(chan *TwitterStatus)
+	go client.Stream(tweets, testurl, &url.Values{})
+Receive:
+	for {
+		select {
+		case <-tweets:
+		case <-client.Errors:
+		case <-client.Finished:
+			break Receive
+		case <-time.After(time.Second):
+			t.Fatal("Stream timeout")
+		}
+	}
+
+	logged := out.String()
+	for _, e := range []string{
+		`level=DEBUG msg="response received" url=https://stream.example.com status=200`,
+		`level=INFO msg="stream connected"`,
+		`level=WARN msg="message not decoded"`,
+		`level=INFO msg="stream disconnected" url=https://stream.example.com error=EOF`,
+	} {
+		if !strings.Contains(logged, e) {
+			t.Errorf("Expecting %s to be logged, got:\n%s", e, logged)
+		}
+	}
+}
+
+func TestClientTokensLogger(t *testing.T) {
+	messages := []string{}
+	tokens := &ClientTokens{
+		TokenFile: newTestTokenFile(t),
+		Logger: LoggerFunc(func(_ context.Context, level slog.Level, msg string, args ...interface{}) {
+			messages = append(messages, level.String()+" "+msg)
+		}),
+	}
+	if err := tokens.Revoke(nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(messages, ", ") != "INFO revoking user token, DEBUG token file written" {
+		t.Errorf("Unexpected events %v", messages)
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Window MessageRate is measured over, in seconds
+	rateWindow = 60
+)
+
+// Metrics is a snapshot of the counters kept for all of a client's streams.
+type Metrics struct {
+	// Messages received by type: "status" for tweets, otherwise the message's top level key
+	// ("delete", "limit", "status_withheld" etc. see StatusView.Kind)
+	Messages map[string]int64
+	// Tweets per second over the last minute
+	MessageRate float64
+	// Bytes read from streams (after decompression)
+	BytesRead int64
+	// Messages which couldn't be decoded
+	DecodeErrors int64
+	// Connection attempts, and those which were to a stream connected to before
+	Connects   int64
+	Reconnects int64
+	// Why connections ended or failed: "eof", "unexpected_eof", "network", "read_error",
+	// "request_error", or "http_" followed by the status code Twitter responded with
+	Disconnects map[string]int64
+	// When the last message was received, and how long ago that was
+	LastMessage      time.Time
+	SinceLastMessage time.Duration
+	// Difference between the local clock and when the last tweet was created (from its
+	// timestamp_ms, falling back to created_at)
+	Lag time.Duration
+}
+
+// Counters behind Metrics, shared with the client's clones.
+type streamMetrics struct {
+	// Updated on every read, so kept out of the mutex (first for 64-bit alignment)
+	bytesRead int64
+
+	mu           sync.Mutex
+	messages     map[string]int64
+	decodeErrors int64
+	connects     int64
+	reconnects   int64
+	disconnects  map[string]int64
+	lastMessage  time.Time
+	lag          time.Duration
+	// Tweets received in each second of the rate window
+	rate       [rateWindow]int64
+	rateSecond [rateWindow]int64
+}
+
+func newStreamMetrics() *streamMetrics {
+	return &streamMetrics{
+		messages:    make(map[string]int64),
+		disconnects: make(map[string]int64),
+	}
+}
+
+// Metrics returns a snapshot of the metrics of all the client's streams.
+func (s *StreamClient) Metrics() Metrics {
+	return s.metrics.snapshot(time.Now())
+}
+
+func (m *streamMetrics) snapshot(now time.Time) Metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metrics := Metrics{
+		Messages:     make(map[string]int64, len(m.messages)),
+		BytesRead:    atomic.LoadInt64(&m.bytesRead),
+		DecodeErrors: m.decodeErrors,
+		Connects:     m.connects,
+		Reconnects:   m.reconnects,
+		Disconnects:  make(map[string]int64, len(m.disconnects)),
+		LastMessage:  m.lastMessage,
+		Lag:          m.lag,
+	}
+	for k, v := range m.messages {
+		metrics.Messages[k] = v
+	}
+	for k, v := range m.disconnects {
+		metrics.Disconnects[k] = v
+	}
+	if !m.lastMessage.IsZero() {
+		metrics.SinceLastMessage = now.Sub(m.lastMessage)
+	}
+
+	var recent int64
+	for i, second := range m.rateSecond {
+		if now.Unix()-second < rateWindow {
+			recent += m.rate[i]
+		}
+	}
+	metrics.MessageRate = float64(recent) / rateWindow
+	return metrics
+}
+
+// Records a connection attempt.
+func (m *streamMetrics) connecting(reconnect bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connects++
+	if reconnect {
+		m.reconnects++
+	}
+}
+
+// Records the error which ended or prevented a connection.
+func (m *streamMetrics) disconnected(err error) {
+	reason := "read_error"
+	switch e := err.(type) {
+	case *TwitterError:
+		reason = "http_" + strconv.Itoa(e.ID)
+	case *net.OpError:
+		reason = "network"
+	default:
+		if err == io.EOF {
+			reason = "eof"
+		} else if err == io.ErrUnexpectedEOF {
+			reason = "unexpected_eof"
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.disconnects[reason]++
+}
+
+// Records that the request for a connection failed before Twitter responded.
+func (m *streamMetrics) requestFailed(err error) {
+	if _, ok := err.(*TwitterError); ok {
+		m.disconnected(err)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.disconnects["request_error"]++
+}
+
+// Records a message which couldn't be decoded.
+func (m *streamMetrics) decodeError() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decodeErrors++
+}
+
+// Records a received message of the given kind.  status is nil for messages which aren't tweets.
+func (m *streamMetrics) message(kind string, status *TwitterStatus, now time.Time) {
+	var created time.Time
+	if status != nil {
+		created = tweetTime(status)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages[kind]++
+	m.lastMessage = now
+	if status == nil {
+		return
+	}
+	i := now.Unix() % rateWindow
+	if m.rateSecond[i] != now.Unix() {
+		m.rateSecond[i] = now.Unix()
+		m.rate[i] = 0
+	}
+	m.rate[i]++
+	if !created.IsZero() {
+		m.lag = now.Sub(created)
+	}
+}
+
+// Returns when a tweet was created, from the millisecond timestamp_ms stream messages
+// include, or created_at.
+func tweetTime(status *TwitterStatus) time.Time {
+	// timestamp_ms is a top level field near the end of the message.  Searching for it
+	// avoids decoding the whole message again.
+	key := []byte(`"timestamp_ms":"`)
+	if i := bytes.LastIndex(status.raw, key); i >= 0 {
+		value := status.raw[i+len(key):]
+		if end := bytes.IndexByte(value, '"'); end > 0 {
+			if ms, err := strconv.ParseInt(string(value[:end]), 10, 64); err == nil {
+				return time.Unix(0, ms*int64(time.Millisecond))
+			}
+		}
+	}
+	return status.CreatedAt.T
+}
+
+// Wraps a stream's body to count the bytes read from it.
+func (m *streamMetrics) countBytes(body io.ReadCloser) io.ReadCloser {
+	return &countingBody{ReadCloser: body, n: &m.bytesRead}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.n, int64(n))
+	return n, err
+}
+
+// PublishExpvar publishes the client's metrics as the expvar variable name (served by
+// expvar's /debug/vars handler).  As with expvar.Publish, name must be unique.
+func (s *StreamClient) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return s.Metrics()
+	}))
+}
+
+// MetricsHandler returns a handler serving the client's metrics in the Prometheus text format.
+func (s *StreamClient) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s.Metrics().writePrometheus(w)
+	})
+}
+
+// Writes the metrics in the Prometheus text format.
+func (m Metrics) writePrometheus(w io.Writer) {
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP streamingtwitter_%s %s\n# TYPE streamingtwitter_%s %s\n", name, help, name, kind)
+	}
+	labelled := func(name, label string, values map[string]int64) {
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "streamingtwitter_%s{%s=%q} %d\n", name, label, k, values[k])
+		}
+	}
+
+	metric("messages_total", "counter", "Messages received by type.")
+	labelled("messages_total", "type", m.Messages)
+	metric("message_rate", "gauge", "Tweets per second over the last minute.")
+	fmt.Fprintf(w, "streamingtwitter_message_rate %g\n", m.MessageRate)
+	metric("bytes_read_total", "counter", "Bytes read from streams.")
+	fmt.Fprintf(w, "streamingtwitter_bytes_read_total %d\n", m.BytesRead)
+	metric("decode_errors_total", "counter", "Messages which couldn't be decoded.")
+	fmt.Fprintf(w, "streamingtwitter_decode_errors_total %d\n", m.DecodeErrors)
+	metric("connects_total", "counter", "Stream connection attempts.")
+	fmt.Fprintf(w, "streamingtwitter_connects_total %d\n", m.Connects)
+	metric("reconnects_total", "counter", "Connection attempts to streams connected to before.")
+	fmt.Fprintf(w, "streamingtwitter_reconnects_total %d\n", m.Reconnects)
+	metric("disconnects_total", "counter", "Ended or failed connections by reason.")
+	labelled("disconnects_total", "reason", m.Disconnects)
+	metric("seconds_since_last_message", "gauge", "Time since the last message was received.")
+	fmt.Fprintf(w, "streamingtwitter_seconds_since_last_message %g\n", m.SinceLastMessage.Seconds())
+	metric("lag_seconds", "gauge", "Time between the last tweet being created and received.")
+	fmt.Fprintf(w, "streamingtwitter_lag_seconds %g\n", m.Lag.Seconds())
+}
@@ -0,0 +1,123 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamMetrics(t *testing.T) {
+	now := time.Now()
+	body := &bytes.Buffer{}
+	fmt.Fprintf(body, "{\"id_str\":\"1\",\"timestamp_ms\":\"%d\"}\r\n", now.Add(-2*time.Second).UnixNano()/int64(time.Millisecond))
+	body.WriteString("{\"id_str\":\"2\"}\r\n")
+	body.WriteString("{\"delete\":{\"status\":{\"id_str\":\"1\"}}}\r\n")
+	body.WriteString("{\"limit\":{\"track\":10}}\r\n")
+	body.WriteString("{\"status_withheld\":{\"id\":3,\"user_id\":4,\"withheld_in_countries\":[\"DE\"]}}\r\n")
+	body.WriteString("{\"id_str\":\"x\",\"text\":1}\r\n")
+
+	client := NewClient()
+	stream := testStreamURL(body.Bytes())
+	collectStream(t, client, stream)
+	collectStream(t, client, stream)
+
+	metrics := client.Metrics()
+	expected := map[string]int64{"status": 4, "delete": 2, "limit": 2, "status_withheld": 2}
+	for kind, n := range expected {
+		if metrics.Messages[kind] != n {
+			t.Errorf("Expecting %v %v messages, got %v", n, kind, metrics.Messages[kind])
+		}
+	}
+	if metrics.BytesRead != int64(2*body.Len()) {
+		t.Errorf("Expecting %v bytes read, got %v", 2*body.Len(), metrics.BytesRead)
+	}
+	if metrics.DecodeErrors != 2 {
+		t.Errorf("Expecting 2 decode errors, got %v", metrics.DecodeErrors)
+	}
+	if metrics.Connects != 2 || metrics.Reconnects != 1 {
+		t.Errorf("Expecting 2 connects and 1 reconnect, got %v and %v", metrics.Connects, metrics.Reconnects)
+	}
+	if metrics.Disconnects["eof"] != 2 {
+		t.Errorf("Expecting 2 eof disconnects, got %v", metrics.Disconnects)
+	}
+	if metrics.LastMessage.Before(now) || metrics.SinceLastMessage < 0 {
+		t.Errorf("Unexpected last message %v (%v ago)", metrics.LastMessage, metrics.SinceLastMessage)
+	}
+	if metrics.MessageRate != 4.0/rateWindow {
+		t.Errorf("Expecting a rate of %v, got %v", 4.0/rateWindow, metrics.MessageRate)
+	}
+}
+
+func TestTweetTime(t *testing.T) {
+	status := new(TwitterStatus)
+	if err := json.Unmarshal(readTestTweet(t), status); err != nil {
+		t.Fatal(err)
+	}
+	if created := tweetTime(status); !created.Equal(status.CreatedAt.T) {
+		t.Errorf("Expecting created_at %v, got %v", status.CreatedAt.T, created)
+	}
+
+	if err := json.Unmarshal([]byte(`{"created_at":"Tue May 20 12:00:00 +0000 2014","timestamp_ms":"1400587201500"}`), status); err != nil {
+		t.Fatal(err)
+	}
+	if created := tweetTime(status); !created.Equal(time.Unix(1400587201, 500*int64(time.Millisecond))) {
+		t.Errorf("Expecting timestamp_ms to be used, got %v", created)
+	}
+}
+
+func TestStreamMetricsLag(t *testing.T) {
+	m := newStreamMetrics()
+	status := new(TwitterStatus)
+	status.raw = []byte(`{"id_str":"1","timestamp_ms":"1400587201000"}`)
+	m.message("status", status, time.Unix(1400587204, 0))
+
+	if lag := m.snapshot(time.Unix(1400587204, 0)).Lag; lag != 3*time.Second {
+		t.Errorf("Expecting a lag of 3s, got %v", lag)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	client := NewClient()
+	client.metrics.message("status", nil, time.Now())
+	client.metrics.disconnected(&TwitterError{ID: 420})
+	client.metrics.requestFailed(fmt.Errorf("dial failed"))
+
+	w := httptest.NewRecorder()
+	client.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	out, _ := ioutil.ReadAll(w.Body)
+	for _, line := range []string{
+		`# TYPE streamingtwitter_messages_total counter`,
+		`streamingtwitter_messages_total{type="status"} 1`,
+		`streamingtwitter_disconnects_total{reason="http_420"} 1`,
+		`streamingtwitter_disconnects_total{reason="request_error"} 1`,
+		`streamingtwitter_connects_total 0`,
+	} {
+		if !strings.Contains(string(out), line+"\n") {
+			t.Errorf("Expecting %q in output:\n%s", line, out)
+		}
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	client := NewClient()
+	client.metrics.decodeError()
+	// Names can only be published once per process, so each run (-count) needs its own
+	name := fmt.Sprintf("streamingtwitter_test_%d", time.Now().UnixNano())
+	client.PublishExpvar(name)
+
+	metrics := Metrics{}
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &metrics); err != nil {
+		t.Fatal(err)
+	}
+	if metrics.DecodeErrors != 1 {
+		t.Errorf("Expecting 1 decode error, got %v", metrics.DecodeErrors)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"net/http"
+)
+
+// A Middleware wraps the http.RoundTripper which sends (already signed) requests to
+// Twitter.  Use it for logging, metrics, request IDs, extra headers, retries or fault
+// injection in tests.
+/*
+ client.Use(func(next http.RoundTripper) http.RoundTripper {
+ 	return streamingtwitter.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+ 		start := time.Now()
+ 		resp, err := next.RoundTrip(r)
+ 		log.Printf("%s %s (%v)", r.Method, r.URL, time.Since(start))
+ 		return resp, err
+ 	})
+ })
+*/
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc allows an ordinary function to be used as an http.RoundTripper.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls f(r).
+func (f RoundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// Use adds middleware around the client's requests.  The first middleware added is the
+// outermost.  Middleware should be added before any requests are made.
+func (s *StreamClient) Use(m ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.middleware = append(s.middleware, m...)
+
+	var rt http.RoundTripper = http.DefaultTransport
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		rt = s.middleware[i](rt)
+	}
+	s.httpClient = &http.Client{Transport: rt}
+}
+
+// HeaderMiddleware returns Middleware which sets the given headers on every request.
+func HeaderMiddleware(h http.Header) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			// RoundTrippers must not modify the original request
+			r = r.Clone(r.Context())
+			for k, v := range h {
+				r.Header[k] = v
+			}
+			return next.RoundTrip(r)
+		})
+	}
+}
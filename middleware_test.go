@@ -0,0 +1,73 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Request-Id") != "abc" || r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	order := []string{}
+	trace := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(r)
+			})
+		}
+	}
+
+	client := NewClient()
+	client.Authenticate(&StaticTokens{App: testAppToken, User: testAppToken})
+	client.Use(trace("outer"), HeaderMiddleware(http.Header{"X-Request-Id": {"abc"}}))
+	client.Use(trace("inner"))
+
+	resp, err := client.sendRequest(&TwitterAPIURL{AccessMethod: "get", URL: ts.URL}, &url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expecting status 200, got %v", resp.StatusCode)
+	}
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Errorf("Unexpected middleware order %v", order)
+	}
+}
+
+func TestMiddlewareFaultInjection(t *testing.T) {
+	client := NewClient()
+	client.Use(func(http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: 420, Body: http.NoBody}, nil
+		})
+	})
+
+	_, err := client.sendRequest(&TwitterAPIURL{AccessMethod: "post", URL: "http://example.com/"}, &url.Values{})
+	if rerr, ok := err.(*TwitterError); !ok || rerr.ID != 420 {
+		t.Errorf("Expecting TwitterError 420, got %v", err)
+	}
+
+	client = NewClient()
+	client.Use(func(http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("connection reset")
+		})
+	})
+	_, err = client.sendRequest(&TwitterAPIURL{AccessMethod: "get", URL: "http://example.com/"}, &url.Values{})
+	if err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("Expecting injected error, got %v", err)
+	}
+}
@@ -0,0 +1,516 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// Limits on the values of a single Filter stream - https://dev.twitter.com/docs/streaming-apis/parameters
+	MaxTrackPerStream     = 400
+	MaxFollowPerStream    = 5000
+	MaxLocationsPerStream = 25
+
+	// Default delay before a StreamManager reconnects a stream
+	defaultRestartDelay = 5 * time.Second
+	// Default delays before reconnecting a stream rejected by Twitter, and the most times
+	// they are doubled - https://dev.twitter.com/streaming/overview/connecting
+	defaultHTTPErrorDelay = 5 * time.Second
+	defaultRateLimitDelay = time.Minute
+	maxBackoffDoublings   = 6
+	// Default time a StreamManager waits for new connections when updating its rules
+	defaultSwapTimeout = time.Minute
+	// Number of recently seen status IDs used to drop duplicates
+	recentIDsSize = 10000
+)
+
+// FilterRules are the track, follow and locations values of a Filter stream.
+type FilterRules struct {
+	// Phrases to track.  Each phrase matches tweets containing all of its space separated words.
+	Track []string
+	// User IDs to follow
+	Follow []string
+	// Bounding boxes to match tweets in
+	Locations []LocationBox
+}
+
+// MatchedStatus is a status received by a StreamManager, with the rules it matched.
+type MatchedStatus struct {
+	Status *TwitterStatus
+	Rules  FilterRules
+	// Index of the connection (see StreamManager.Shards) the status was received on
+	Shard int
+}
+
+// StreamShardError is an error from one of a StreamManager's connections.
+type StreamShardError struct {
+	Shard int
+	Err   error
+}
+
+// StreamManager splits a large set of Filter rules across as many connections as Twitter's
+// limits require, and merges the statuses received on them into one channel.  Each
+// connection is reconnected independently when it fails.
+type StreamManager struct {
+	// Client whose credentials and settings are used for every connection
+	Client *StreamClient
+	// Stream to connect to, Streams["Filter"] by default
+	Stream *TwitterAPIURL
+	// Delay before a failed connection is reconnected (5s by default)
+	RestartDelay time.Duration
+	// Delays before a connection rejected by Twitter is reconnected, for HTTP errors (5s
+	// by default) and rate limiting (420, 1 minute by default).  As Twitter requires, they
+	// double with each rejection in a row (up to 64 times), until the connection receives
+	// a status again.
+	HTTPErrorDelay time.Duration
+	RateLimitDelay time.Duration
+	// Longest time Update waits for the new connections to deliver a status before closing
+	// the old ones (1 minute by default)
+	SwapTimeout time.Duration
+	// Errors from the connections are sent here as a *StreamShardError
+	Errors chan error
+
+	recent *recentIDs
+	// Serialises calls to Update
+	updating sync.Mutex
+
+	// Guards the fields below
+	mu      sync.Mutex
+	current *generation
+	running []*generation
+	out     chan<- *MatchedStatus
+	shards  sync.WaitGroup
+	stop    chan struct{}
+}
+
+// A set of connections streaming the same rules.  Update replaces the manager's generation.
+type generation struct {
+	rules  FilterRules
+	shards []FilterRules
+
+	clients []*StreamClient
+	stop    chan struct{}
+	// Number of shards yet to receive a status, delivering is closed when it reaches 0
+	pending    int
+	delivering chan struct{}
+}
+
+// NewStreamManager creates a manager which streams statuses matching rules using client.
+func NewStreamManager(client *StreamClient, rules FilterRules) *StreamManager {
+	return &StreamManager{
+		Client:         client,
+		Stream:         Streams["Filter"],
+		RestartDelay:   defaultRestartDelay,
+		HTTPErrorDelay: defaultHTTPErrorDelay,
+		RateLimitDelay: defaultRateLimitDelay,
+		SwapTimeout:    defaultSwapTimeout,
+		Errors:         make(chan error),
+		recent:         newRecentIDs(recentIDsSize),
+		current:        newGeneration(rules),
+		stop:           make(chan struct{}),
+	}
+}
+
+func newGeneration(rules FilterRules) *generation {
+	shards := PartitionRules(rules)
+	return &generation{
+		rules:      rules,
+		shards:     shards,
+		stop:       make(chan struct{}),
+		pending:    len(shards),
+		delivering: make(chan struct{}),
+	}
+}
+
+// Shards returns the rules of each of the manager's connections.
+func (m *StreamManager) Shards() []FilterRules {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current.shards
+}
+
+// Run connects every shard and sends matching statuses on out until Stop is called.  A
+// status matching rules on more than one connection is only sent once.
+func (m *StreamManager) Run(out chan<- *MatchedStatus) {
+	m.mu.Lock()
+	select {
+	case <-m.stop:
+		m.mu.Unlock()
+		return
+	default:
+	}
+	m.out = out
+	m.start(m.current)
+	m.mu.Unlock()
+
+	<-m.stop
+	m.shards.Wait()
+}
+
+// Update replaces the rules of the running manager without missing statuses, following
+// Twitter's guidance on updating filter predicates: the connections for the new rules are
+// opened first, and the old connections are only closed once the new ones are delivering
+// (or after SwapTimeout).  Statuses received on both during the overlap are only sent once.
+// Update returns when the old connections have been closed.
+func (m *StreamManager) Update(rules FilterRules) {
+	m.updating.Lock()
+	defer m.updating.Unlock()
+
+	next := newGeneration(rules)
+	m.mu.Lock()
+	select {
+	case <-m.stop:
+		m.mu.Unlock()
+		return
+	default:
+	}
+	old := m.current
+	m.current = next
+	started := m.out != nil
+	if started {
+		m.start(next)
+	}
+	m.mu.Unlock()
+	if !started {
+		return
+	}
+
+	select {
+	case <-next.delivering:
+	case <-time.After(m.SwapTimeout):
+	case <-m.stop:
+		return
+	}
+	m.mu.Lock()
+	m.halt(old)
+	m.mu.Unlock()
+}
+
+// Stop disconnects all of the manager's connections, and Run then returns.
+func (m *StreamManager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.stop:
+		return
+	default:
+	}
+	close(m.stop)
+	for len(m.running) > 0 {
+		m.halt(m.running[0])
+	}
+}
+
+// Connects each shard of a generation.  m.mu must be held.
+func (m *StreamManager) start(g *generation) {
+	m.running = append(m.running, g)
+	for i := range g.shards {
+		client := m.Client.clone()
+		g.clients = append(g.clients, client)
+
+		m.shards.Add(1)
+		go func(i int) {
+			defer m.shards.Done()
+			m.runShard(g, i, client)
+		}(i)
+	}
+}
+
+// Disconnects a generation's shards.  m.mu must be held.
+func (m *StreamManager) halt(g *generation) {
+	for i, r := range m.running {
+		if r == g {
+			m.running = append(m.running[:i], m.running[i+1:]...)
+			close(g.stop)
+			for _, client := range g.clients {
+				client.shutdown()
+			}
+			return
+		}
+	}
+}
+
+// Records that a shard of a generation has received its first status.
+func (m *StreamManager) delivered(g *generation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g.pending--
+	if g.pending == 0 {
+		close(g.delivering)
+	}
+}
+
+// Streams a single shard, reconnecting (see reconnectDelay) until its generation is stopped.
+func (m *StreamManager) runShard(g *generation, shard int, client *StreamClient) {
+	values := g.shards[shard].Values()
+	first := true
+	var backoff shardBackoff
+	for {
+		select {
+		case <-g.stop:
+			return
+		default:
+		}
+
+		tweets := make(chan *TwitterStatus)
+		ended := make(chan struct{})
+		go func() {
+			client.Stream(tweets, m.Stream, &values)
+			close(ended)
+		}()
+		received, err := m.receive(g, shard, client, tweets, ended, &first)
+
+		select {
+		case <-g.stop:
+			return
+		default:
+		}
+		delay := m.reconnectDelay(&backoff, received, err)
+		logEvent(client.Logger, slog.LevelInfo, "reconnecting stream shard", "shard", shard, "delay", delay)
+		select {
+		case <-g.stop:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Rejections in a row of a shard's connections.
+type shardBackoff struct {
+	httpErrors, rateLimits int
+}
+
+// Returns how long to wait before reconnecting a shard whose connection ended with err,
+// backing off exponentially while Twitter rejects its connections.
+func (m *StreamManager) reconnectDelay(b *shardBackoff, received bool, err error) time.Duration {
+	if received {
+		*b = shardBackoff{}
+	}
+	terr, ok := err.(*TwitterError)
+	if !ok {
+		return m.RestartDelay
+	}
+	delay, n := m.HTTPErrorDelay, &b.httpErrors
+	if terr.ID == 420 {
+		delay, n = m.RateLimitDelay, &b.rateLimits
+	}
+	for i := 0; i < *n && i < maxBackoffDoublings; i++ {
+		delay *= 2
+	}
+	*n++
+	return delay
+}
+
+// Handles one connection of a shard until it ends, returning whether it received any
+// statuses and the last error it sent.
+func (m *StreamManager) receive(g *generation, shard int, client *StreamClient, tweets <-chan *TwitterStatus, ended <-chan struct{}, first *bool) (received bool, last error) {
+	for {
+		select {
+		case status := <-tweets:
+			received = true
+			if *first {
+				*first = false
+				m.delivered(g)
+			}
+			if m.recent.seen(status.ID) {
+				continue
+			}
+			select {
+			case m.out <- &MatchedStatus{Status: status, Rules: g.rules.Matching(status), Shard: shard}:
+			case <-g.stop:
+			}
+		case err := <-client.Errors:
+			last = err
+			select {
+			case m.Errors <- &StreamShardError{Shard: shard, Err: err}:
+			case <-g.stop:
+			}
+		case <-client.Finished:
+		case <-ended:
+			return
+		}
+	}
+}
+
+// PartitionRules splits rules into as few sets as possible within the limits of a single
+// Filter stream.
+func PartitionRules(rules FilterRules) []FilterRules {
+	n := 1
+	for _, c := range []int{
+		(len(rules.Track) + MaxTrackPerStream - 1) / MaxTrackPerStream,
+		(len(rules.Follow) + MaxFollowPerStream - 1) / MaxFollowPerStream,
+		(len(rules.Locations) + MaxLocationsPerStream - 1) / MaxLocationsPerStream,
+	} {
+		if c > n {
+			n = c
+		}
+	}
+
+	shards := make([]FilterRules, n)
+	for i := range shards {
+		shards[i].Track = chunk(rules.Track, i, MaxTrackPerStream)
+		shards[i].Follow = chunk(rules.Follow, i, MaxFollowPerStream)
+		if lo := i * MaxLocationsPerStream; lo < len(rules.Locations) {
+			hi := lo + MaxLocationsPerStream
+			if hi > len(rules.Locations) {
+				hi = len(rules.Locations)
+			}
+			shards[i].Locations = rules.Locations[lo:hi]
+		}
+	}
+	return shards
+}
+
+// Returns the i'th chunk of size values.
+func chunk(values []string, i, size int) []string {
+	lo := i * size
+	if lo >= len(values) {
+		return nil
+	}
+	hi := lo + size
+	if hi > len(values) {
+		hi = len(values)
+	}
+	return values[lo:hi]
+}
+
+// Values returns the rules as Filter stream parameters.
+func (r FilterRules) Values() url.Values {
+	values := url.Values{}
+	if len(r.Track) > 0 {
+		values.Set("track", strings.Join(r.Track, ","))
+	}
+	if len(r.Follow) > 0 {
+		values.Set("follow", strings.Join(r.Follow, ","))
+	}
+	if len(r.Locations) > 0 {
+		boxes := make([]string, len(r.Locations))
+		for i, b := range r.Locations {
+			boxes[i] = b.String()
+		}
+		values.Set("locations", strings.Join(boxes, ","))
+	}
+	return values
+}
+
+// Matching returns the rules which status matches, approximately as Twitter does: the
+// words of track phrases are matched case insensitively against the words of the text,
+// hashtags, mentions and expanded URLs; follow against the author and the user replied
+// to or retweeted; and locations against the status's Location().
+func (r FilterRules) Matching(status *TwitterStatus) (matched FilterRules) {
+	if len(r.Track) > 0 {
+		tokens := make(map[string]bool)
+		for _, t := range trackTokens(trackContent(status)) {
+			tokens[t] = true
+		}
+		for _, phrase := range r.Track {
+			words := trackTokens(phrase)
+			all := len(words) > 0
+			for _, w := range words {
+				if !tokens[w] {
+					all = false
+					break
+				}
+			}
+			if all {
+				matched.Track = append(matched.Track, phrase)
+			}
+		}
+	}
+
+	if len(r.Follow) > 0 {
+		users := map[string]bool{
+			status.User.ID:          true,
+			status.ReplyToUserIDStr: true,
+			retweetedUserID(status): true,
+		}
+		for _, id := range r.Follow {
+			if id != "" && users[id] {
+				matched.Follow = append(matched.Follow, id)
+			}
+		}
+	}
+
+	if pt, ok := status.Location(); ok {
+		for _, b := range r.Locations {
+			if b.Contains(pt) {
+				matched.Locations = append(matched.Locations, b)
+			}
+		}
+	}
+	return
+}
+
+// Splits text into the lower cased words which track phrases are matched on.
+func trackTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+// Returns the content of a status which track phrases are matched against.
+func trackContent(status *TwitterStatus) string {
+	parts := []string{status.Text}
+	for _, h := range status.Entities.Hashtags {
+		parts = append(parts, h.Text)
+	}
+	for _, m := range status.Entities.UserMentions {
+		parts = append(parts, m.ScreenName)
+	}
+	for _, u := range status.Entities.URLs {
+		parts = append(parts, u.ExpandedURL)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Returns the ID of the author of the retweeted status, if any.
+func retweetedUserID(status *TwitterStatus) string {
+	user, _ := status.RetweetedStatus["user"].(map[string]interface{})
+	id, _ := user["id_str"].(string)
+	return id
+}
+
+func (e *StreamShardError) Error() string {
+	return fmt.Sprintf("stream shard %d: %v", e.Shard, e.Err)
+}
+
+// A fixed size set of the most recently seen status IDs.
+type recentIDs struct {
+	mu   sync.Mutex
+	ids  map[string]bool
+	ring []string
+	next int
+}
+
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{
+		ids:  make(map[string]bool, size),
+		ring: make([]string, size),
+	}
+}
+
+// Reports whether id was already seen, and records it otherwise.  Messages without an ID
+// are never considered seen.
+func (r *recentIDs) seen(id string) bool {
+	if id == "" {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ids[id] {
+		return true
+	}
+	delete(r.ids, r.ring[r.next])
+	r.ring[r.next] = id
+	r.next = (r.next + 1) % len(r.ring)
+	r.ids[id] = true
+	return false
+}
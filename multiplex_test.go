@@ -0,0 +1,326 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/garyburd/go-oauth/oauth"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPartitionRules(t *testing.T) {
+	rules := FilterRules{
+		Track:     make([]string, 900),
+		Follow:    make([]string, 100),
+		Locations: make([]LocationBox, 30),
+	}
+	shards := PartitionRules(rules)
+	if len(shards) != 3 {
+		t.Fatalf("Expecting 3 shards, got %v", len(shards))
+	}
+	testData := []struct {
+		track, follow, locations int
+	}{
+		{400, 100, 25},
+		{400, 0, 5},
+		{100, 0, 0},
+	}
+	for i, d := range testData {
+		s := shards[i]
+		if len(s.Track) != d.track || len(s.Follow) != d.follow || len(s.Locations) != d.locations {
+			t.Errorf("Shard %v: unexpected sizes %v %v %v", i, len(s.Track), len(s.Follow), len(s.Locations))
+		}
+	}
+
+	if shards := PartitionRules(FilterRules{}); len(shards) != 1 {
+		t.Errorf("Expecting 1 shard for empty rules, got %v", len(shards))
+	}
+}
+
+func TestFilterRulesValues(t *testing.T) {
+	rules := FilterRules{
+		Track:     []string{"golang", "twitter api"},
+		Follow:    []string{"1", "2"},
+		Locations: []LocationBox{{SouthWest: Point{-122.75, 36.8}, NorthEast: Point{-121.75, 37.8}}},
+	}
+	e := "follow=1%2C2&locations=-122.75%2C36.8%2C-121.75%2C37.8&track=golang%2Ctwitter+api"
+	if v := rules.Values().Encode(); v != e {
+		t.Errorf("Expecting %v, got %v", e, v)
+	}
+}
+
+func TestFilterRulesMatching(t *testing.T) {
+	status := &TwitterStatus{
+		Text:             "Learning Go with the Twitter API's docs",
+		ReplyToUserIDStr: "42",
+		User:             TwitterUser{ID: "7"},
+		Entities: TwitterEntity{
+			Hashtags: []TweetHashTag{{Text: "gophers"}},
+		},
+		Coordinates: TwitterCoordinate{Type: "Point", Coordinates: Point{-122.4, 37.7}},
+	}
+	rules := FilterRules{
+		Track:     []string{"twitter api", "GOPHERS", "python", "api python", "twit"},
+		Follow:    []string{"7", "42", "8"},
+		Locations: []LocationBox{{SouthWest: Point{-123, 37}, NorthEast: Point{-122, 38}}, {SouthWest: Point{0, 0}, NorthEast: Point{1, 1}}},
+	}
+	matched := rules.Matching(status)
+	if fmt.Sprint(matched.Track) != "[twitter api GOPHERS]" || fmt.Sprint(matched.Follow) != "[7 42]" || len(matched.Locations) != 1 {
+		t.Errorf("Unexpected matches %+v", matched)
+	}
+}
+
+func TestRecentIDs(t *testing.T) {
+	r := newRecentIDs(2)
+	for _, id := range []string{"1", "2"} {
+		if r.seen(id) {
+			t.Errorf("%v not seen yet", id)
+		}
+	}
+	if !r.seen("1") {
+		t.Error("Expecting 1 to be seen")
+	}
+	// Evicts 1
+	r.seen("3")
+	if r.seen("1") {
+		t.Error("Expecting 1 to be evicted")
+	}
+	if r.seen("") || r.seen("") {
+		t.Error("Empty IDs are never seen")
+	}
+}
+
+func TestStreamManager(t *testing.T) {
+	var mu sync.Mutex
+	connections := map[string]int{}
+	handler := func(_ *http.Client, _ *oauth.Credentials, _ string, values url.Values) (*http.Response, error) {
+		first := strings.Split(values.Get("track"), ",")[0]
+		mu.Lock()
+		connections[first]++
+		mu.Unlock()
+		body := fmt.Sprintf("{\"id_str\":\"%s\",\"text\":\"%s\"}\r\n{\"id_str\":\"shared\",\"text\":\"both\"}\r\n", first, first)
+		return &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString(body))}, nil
+	}
+
+	track := make([]string, MaxTrackPerStream+1)
+	for i := range track {
+		track[i] = fmt.Sprintf("t%d", i)
+	}
+	m := NewStreamManager(NewClient(), FilterRules{Track: track})
+	m.Stream = &TwitterAPIURL{AccessMethod: "custom", CustomHandler: handler}
+	m.RestartDelay = time.Millisecond
+	defer m.Stop()
+
+	out := make(chan *MatchedStatus)
+	finished := make(chan struct{})
+	go func() {
+		m.Run(out)
+		close(finished)
+	}()
+
+	received := map[string]int{}
+	timeout := time.After(time.Second)
+	for len(received) < 3 {
+		select {
+		case ms := <-out:
+			received[ms.Status.ID]++
+			if ms.Status.ID == "t400" && (ms.Shard != 1 || fmt.Sprint(ms.Rules.Track) != "[t400]") {
+				t.Errorf("Unexpected labels %+v", ms)
+			}
+		case <-m.Errors:
+		case <-timeout:
+			t.Fatalf("Stream timeout, received %v", received)
+		}
+	}
+	// Wait for the shards to reconnect
+	for {
+		mu.Lock()
+		restarted := connections["t0"] > 1 && connections["t400"] > 1
+		mu.Unlock()
+		if restarted {
+			break
+		}
+		select {
+		case ms := <-out:
+			received[ms.Status.ID]++
+		case <-m.Errors:
+		case <-timeout:
+			t.Fatal("Shards not reconnected")
+		}
+	}
+
+	m.Stop()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after Stop")
+	}
+	for id, n := range received {
+		if n != 1 {
+			t.Errorf("Status %v received %v times", id, n)
+		}
+	}
+}
+
+// A stream body which stays open until it is closed.
+type openBody struct {
+	*io.PipeReader
+	closed func()
+}
+
+func (b openBody) Close() error {
+	b.closed()
+	return b.PipeReader.Close()
+}
+
+func TestStreamManagerUpdate(t *testing.T) {
+	var mu sync.Mutex
+	closed := map[string]bool{}
+	handler := func(_ *http.Client, _ *oauth.Credentials, _ string, values url.Values) (*http.Response, error) {
+		track := values.Get("track")
+		r, w := io.Pipe()
+		go fmt.Fprintf(w, "{\"id_str\":\"%s\",\"text\":\"%s\"}\r\n{\"id_str\":\"shared\",\"text\":\"old new\"}\r\n", track, track)
+		body := openBody{r, func() {
+			mu.Lock()
+			closed[track] = true
+			mu.Unlock()
+		}}
+		return &http.Response{Body: body}, nil
+	}
+
+	m := NewStreamManager(NewClient(), FilterRules{Track: []string{"old"}})
+	m.Stream = &TwitterAPIURL{AccessMethod: "custom", CustomHandler: handler}
+	m.RestartDelay = time.Hour
+	defer m.Stop()
+
+	out := make(chan *MatchedStatus)
+	go m.Run(out)
+
+	received := map[string]int{}
+	receive := func(n int) {
+		timeout := time.After(time.Second)
+		for len(received) < n {
+			select {
+			case ms := <-out:
+				received[ms.Status.ID]++
+				if ms.Status.ID == "new" && fmt.Sprint(ms.Rules.Track) != "[new]" {
+					t.Errorf("Unexpected labels %+v", ms.Rules)
+				}
+			case <-m.Errors:
+			case <-timeout:
+				t.Fatalf("Stream timeout, received %v", received)
+			}
+		}
+	}
+	receive(2)
+
+	updated := make(chan struct{})
+	go func() {
+		m.Update(FilterRules{Track: []string{"new"}})
+		close(updated)
+	}()
+	receive(3)
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatal("Update did not return")
+	}
+
+	mu.Lock()
+	if !closed["old"] || closed["new"] {
+		t.Errorf("Expecting only the old connection to be closed, got %v", closed)
+	}
+	mu.Unlock()
+	if shards := m.Shards(); len(shards) != 1 || fmt.Sprint(shards[0].Track) != "[new]" {
+		t.Errorf("Unexpected shards %+v", shards)
+	}
+	for id, n := range received {
+		if n != 1 {
+			t.Errorf("Status %v received %v times", id, n)
+		}
+	}
+}
+
+func TestStreamManagerStopWhileConnecting(t *testing.T) {
+	connecting := make(chan struct{})
+	respond := make(chan struct{})
+	closed := make(chan struct{})
+	handler := func(*http.Client, *oauth.Credentials, string, url.Values) (*http.Response, error) {
+		close(connecting)
+		<-respond
+		pr, _ := io.Pipe()
+		var once sync.Once
+		return &http.Response{Body: openBody{pr, func() { once.Do(func() { close(closed) }) }}}, nil
+	}
+
+	m := NewStreamManager(NewClient(), FilterRules{Track: []string{"t0"}})
+	m.Stream = &TwitterAPIURL{AccessMethod: "custom", CustomHandler: handler}
+	m.RestartDelay = time.Hour
+
+	finished := make(chan struct{})
+	go func() {
+		m.Run(make(chan *MatchedStatus))
+		close(finished)
+	}()
+	go func() {
+		for range m.Errors {
+		}
+	}()
+
+	// Stop before Twitter has responded, and respond once the stop has been handled
+	<-connecting
+	m.Stop()
+	time.Sleep(50 * time.Millisecond)
+	close(respond)
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Stream connected after Stop was not closed")
+	}
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after Stop")
+	}
+}
+
+func TestStreamManagerReconnectDelay(t *testing.T) {
+	m := NewStreamManager(NewClient(), FilterRules{})
+	var b shardBackoff
+	testData := []struct {
+		received bool
+		err      error
+		expected time.Duration
+	}{
+		{false, &TwitterError{ID: 420}, time.Minute},
+		{false, &TwitterError{ID: 420}, 2 * time.Minute},
+		{false, &TwitterError{ID: 503}, 5 * time.Second},
+		{false, &TwitterError{ID: 420}, 4 * time.Minute},
+		{false, io.EOF, 5 * time.Second},
+		{false, &TwitterError{ID: 503}, 10 * time.Second},
+		{false, &TwitterError{ID: 503}, 20 * time.Second},
+		{false, &TwitterError{ID: 503}, 40 * time.Second},
+		{false, &TwitterError{ID: 503}, 80 * time.Second},
+		{false, &TwitterError{ID: 503}, 160 * time.Second},
+		{false, &TwitterError{ID: 503}, 320 * time.Second},
+		{false, &TwitterError{ID: 503}, 320 * time.Second},
+		// Backing off starts again once statuses are received
+		{true, io.EOF, 5 * time.Second},
+		{false, &TwitterError{ID: 420}, time.Minute},
+		{false, &TwitterError{ID: 503}, 5 * time.Second},
+	}
+	for i, d := range testData {
+		if delay := m.reconnectDelay(&b, d.received, d.err); delay != d.expected {
+			t.Errorf("%v: expecting %v, got %v", i, d.expected, delay)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// A single message from a stream, numbered in the order it was received.
+type frame struct {
+	seq  uint64
+	data []byte
+}
+
+// A decoded frame.
+type decoded struct {
+	seq    uint64
+	status *TwitterStatus
+	err    error
+}
+
+// Reads the stream on the calling goroutine, splitting it into messages which are decoded
+// by s.DecodeWorkers goroutines.  Returns the error which ended the stream, once every
+// message read before it has been delivered.
+func (s *StreamClient) decodeParallel(r io.Reader, activity *streamActivity, tweets chan<- *TwitterStatus, queue *statusQueue) error {
+	frames := make(chan frame, s.DecodeWorkers*2)
+	results := make(chan decoded, s.DecodeWorkers*2)
+
+	var workers sync.WaitGroup
+	for i := 0; i < s.DecodeWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for f := range frames {
+				status := s.newStatus()
+				if err := json.Unmarshal(f.data, status); err != nil {
+					status.Release()
+					results <- decoded{seq: f.seq, err: err}
+					continue
+				}
+				results <- decoded{seq: f.seq, status: status}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.collect(results, activity, tweets, queue)
+		close(done)
+	}()
+
+	var seq uint64
+	err := splitFrames(r, func(data []byte) {
+		frames <- frame{seq: seq, data: data}
+		seq++
+	})
+	close(frames)
+	workers.Wait()
+	close(results)
+	<-done
+	return err
+}
+
+// Delivers decoded messages, re-sequencing them first when s.PreserveOrder is set.
+func (s *StreamClient) collect(results <-chan decoded, activity *streamActivity, tweets chan<- *TwitterStatus, queue *statusQueue) {
+	send := func(d decoded) {
+		if d.err != nil {
+			logEvent(s.Logger, slog.LevelWarn, "message not decoded", "error", d.err)
+			s.metrics.decodeError()
+			s.Errors <- d.err
+			return
+		}
+		s.deliver(activity, d.status, tweets, queue)
+	}
+
+	if !s.PreserveOrder {
+		for d := range results {
+			send(d)
+		}
+		return
+	}
+
+	// Messages decoded ahead of an earlier one wait here.  There can be no more of them
+	// than are in flight between the frames and results channels.
+	pending := make(map[uint64]decoded)
+	var next uint64
+	for d := range results {
+		pending[d.seq] = d
+		for {
+			d, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			send(d)
+			next++
+		}
+	}
+}
+
+// Splits the stream into messages, which Twitter delimits with \r\n, calling fn with each
+// one.  Blank lines are keep-alives and are skipped.  Returns the error which ended the stream.
+func splitFrames(r io.Reader, fn func([]byte)) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if data := bytes.TrimSpace(line); len(data) > 0 {
+			fn(data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
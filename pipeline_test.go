@@ -0,0 +1,149 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/garyburd/go-oauth/oauth"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Returns a custom stream URL which sends body.
+func testStreamURL(body []byte) *TwitterAPIURL {
+	return &TwitterAPIURL{
+		AccessMethod: "custom",
+		CustomHandler: func(*http.Client, *oauth.Credentials, string, url.Values) (*http.Response, error) {
+			return &http.Response{Body: ioutil.NopCloser(bytes.NewReader(body))}, nil
+		},
+	}
+}
+
+// Streams from client until Finished, returning the IDs received and any errors other than EOF.
+func collectStream(t *testing.T, client *StreamClient, stream *TwitterAPIURL) (ids []string, errs []error) {
+	tweets := make(chan *TwitterStatus)
+	go client.Stream(tweets, stream, &url.Values{})
+	for {
+		select {
+		case status := <-tweets:
+			ids = append(ids, status.ID)
+		case err := <-client.Errors:
+			if err.Error() != "EOF" {
+				errs = append(errs, err)
+			}
+		case <-client.Finished:
+			return
+		case <-time.After(time.Second):
+			t.Fatal("Stream timeout")
+		}
+	}
+}
+
+func TestParallelDecodePreservesOrder(t *testing.T) {
+	body := &bytes.Buffer{}
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(body, "{\"id_str\":\"%d\"}\r\n", i)
+		if i == 50 {
+			// Keep-alive and a message which can't be decoded
+			body.WriteString("\r\n{\"id_str\":\"x\",\"text\":1}\r\n")
+		}
+	}
+
+	client := NewClient()
+	client.DecodeWorkers = 4
+	client.PreserveOrder = true
+	ids, errs := collectStream(t, client, testStreamURL(body.Bytes()))
+
+	if len(ids) != 200 {
+		t.Fatalf("Expecting 200 statuses, got %v", len(ids))
+	}
+	for i, id := range ids {
+		if id != strconv.Itoa(i) {
+			t.Fatalf("Expecting status %v at %v, got %v", i, i, id)
+		}
+	}
+	if len(errs) != 1 {
+		t.Errorf("Expecting 1 decoding error, got %v", errs)
+	}
+}
+
+func TestParallelDecodeUnordered(t *testing.T) {
+	body := &bytes.Buffer{}
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(body, "{\"id_str\":\"%d\"}\r\n", i)
+	}
+	// Final message without a delimiter
+	body.WriteString(`{"id_str":"100"}`)
+
+	client := NewClient()
+	client.DecodeWorkers = 4
+	ids, errs := collectStream(t, client, testStreamURL(body.Bytes()))
+
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors %v", errs)
+	}
+	seen := make([]int, 0, len(ids))
+	for _, id := range ids {
+		n, _ := strconv.Atoi(id)
+		seen = append(seen, n)
+	}
+	sort.Ints(seen)
+	for i := 0; i <= 100; i++ {
+		if i >= len(seen) || seen[i] != i {
+			t.Fatalf("Status %v missing", i)
+		}
+	}
+}
+
+// Returns a stream body of n copies of test_data/tweet.json, one per line.
+func benchmarkStreamBody(b *testing.B, n int) (body []byte, size int) {
+	tweet, err := ioutil.ReadFile("test_data/tweet.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	line := &bytes.Buffer{}
+	if err := json.Compact(line, tweet); err != nil {
+		b.Fatal(err)
+	}
+	line.WriteString("\r\n")
+	return bytes.Repeat(line.Bytes(), n), line.Len()
+}
+
+func benchmarkStream(b *testing.B, workers int, ordered bool) {
+	body, size := benchmarkStreamBody(b, b.N)
+	client := NewClient()
+	client.DecodeWorkers = workers
+	client.PreserveOrder = ordered
+	tweets := make(chan *TwitterStatus, 100)
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	go client.Stream(tweets, testStreamURL(body), &url.Values{})
+	for {
+		select {
+		case <-tweets:
+		case <-client.Errors:
+		case <-client.Finished:
+			return
+		}
+	}
+}
+
+func BenchmarkStreamDecode(b *testing.B) {
+	benchmarkStream(b, 0, false)
+}
+
+func BenchmarkStreamDecodeParallel(b *testing.B) {
+	benchmarkStream(b, 4, false)
+}
+
+func BenchmarkStreamDecodeParallelOrdered(b *testing.B) {
+	benchmarkStream(b, 4, true)
+}
@@ -0,0 +1,157 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/garyburd/go-oauth/oauth"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// HTTP methods for the supported TwitterAPIURL.AccessMethod values
+	accessMethods = map[string]string{
+		"":       "GET",
+		"get":    "GET",
+		"post":   "POST",
+		"put":    "PUT",
+		"delete": "DELETE",
+	}
+)
+
+// MultipartBody is a multipart/form-data request body, as used by media/upload.
+type MultipartBody struct {
+	Fields url.Values
+	Files  []MultipartFile
+}
+
+// MultipartFile is a file sent within a multipart/form-data request body.
+type MultipartFile struct {
+	Field    string
+	Filename string
+	Content  []byte
+}
+
+// Builds the request for a Twitter API URL.  formValues are sent in the query string,
+// unless the request is a form encoded post or put, in which case they make up the body.
+// body is only used for json and multipart encoded requests.  The parameters which
+// must be included in the OAuth signature are also returned.
+func newRequest(stream *TwitterAPIURL, formValues url.Values, body interface{}) (*http.Request, url.Values, error) {
+	method, ok := accessMethods[stream.AccessMethod]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported access method %q", stream.AccessMethod)
+	}
+	hasBody := method == "POST" || method == "PUT"
+
+	var r io.Reader
+	contentType := ""
+	switch stream.BodyEncoding {
+	case "", "form":
+		if body != nil {
+			return nil, nil, fmt.Errorf("a request body can only be sent with json or multipart encoding")
+		}
+		if hasBody {
+			r = strings.NewReader(formValues.Encode())
+			contentType = "application/x-www-form-urlencoded"
+		}
+	case "json":
+		if !hasBody {
+			return nil, nil, fmt.Errorf("json encoding requires a post or put request")
+		}
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		r = bytes.NewReader(b)
+		contentType = "application/json"
+	case "multipart":
+		if !hasBody {
+			return nil, nil, fmt.Errorf("multipart encoding requires a post or put request")
+		}
+		mb, ok := body.(*MultipartBody)
+		if !ok {
+			return nil, nil, fmt.Errorf("multipart encoding requires a *MultipartBody")
+		}
+		b, ct, err := mb.encode()
+		if err != nil {
+			return nil, nil, err
+		}
+		r = bytes.NewReader(b)
+		contentType = ct
+	default:
+		return nil, nil, fmt.Errorf("unsupported body encoding %q", stream.BodyEncoding)
+	}
+
+	u := stream.URL
+	if contentType != "application/x-www-form-urlencoded" && len(formValues) > 0 {
+		u += "?" + formValues.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	// Only form encoded bodies are part of the OAuth signature
+	return req, formValues, nil
+}
+
+// Writes the multipart body, returning it and its content type.
+func (mb *MultipartBody) encode() ([]byte, string, error) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	for k, vs := range mb.Fields {
+		for _, v := range vs {
+			if err := w.WriteField(k, v); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	for _, f := range mb.Files {
+		part, err := w.CreateFormFile(f.Field, f.Filename)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(f.Content); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+// Builds, signs and sends a request for a Twitter API URL.
+func (s *StreamClient) do(c *http.Client, token *oauth.Credentials, bearer string, stream *TwitterAPIURL, formValues url.Values, body interface{}) (*http.Response, error) {
+	req, params, err := newRequest(stream, formValues, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	} else {
+		// The signature base string uses the URL without its query
+		u := *req.URL
+		u.RawQuery = ""
+		if err := s.oauthClient.SetAuthorizationHeader(req.Header, token, req.Method, &u, params); err != nil {
+			return nil, err
+		}
+	}
+	if s.Gzip {
+		// Set explicitly so the response is decompressed by decompress() as it is read
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	return c.Do(req)
+}
@@ -0,0 +1,130 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNewRequestMethods(t *testing.T) {
+	values := url.Values{"id": {"123"}}
+	testData := []struct {
+		accessMethod string
+		method       string
+		query        string
+		contentType  string
+	}{
+		{"", "GET", "id=123", ""},
+		{"get", "GET", "id=123", ""},
+		{"delete", "DELETE", "id=123", ""},
+		{"post", "POST", "", "application/x-www-form-urlencoded"},
+		{"put", "PUT", "", "application/x-www-form-urlencoded"},
+	}
+
+	for _, d := range testData {
+		req, params, err := newRequest(&TwitterAPIURL{AccessMethod: d.accessMethod, URL: "https://api.twitter.com/x.json"}, values, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Method != d.method || req.URL.RawQuery != d.query || req.Header.Get("Content-Type") != d.contentType {
+			t.Errorf("%q: unexpected request %v %v (%v)", d.accessMethod, req.Method, req.URL, req.Header.Get("Content-Type"))
+		}
+		if params.Get("id") != "123" {
+			t.Errorf("%q: form values not signed", d.accessMethod)
+		}
+	}
+}
+
+func TestNewRequestErrors(t *testing.T) {
+	testData := []struct {
+		n    string
+		u    *TwitterAPIURL
+		body interface{}
+	}{
+		{"unknown method", &TwitterAPIURL{AccessMethod: "patch"}, nil},
+		{"unknown encoding", &TwitterAPIURL{AccessMethod: "post", BodyEncoding: "xml"}, nil},
+		{"form body", &TwitterAPIURL{AccessMethod: "post"}, struct{}{}},
+		{"json get", &TwitterAPIURL{AccessMethod: "get", BodyEncoding: "json"}, struct{}{}},
+		{"multipart body type", &TwitterAPIURL{AccessMethod: "post", BodyEncoding: "multipart"}, struct{}{}},
+	}
+	for _, d := range testData {
+		if _, _, err := newRequest(d.u, url.Values{}, d.body); err == nil {
+			t.Errorf("%v: expecting error", d.n)
+		}
+	}
+
+	client := NewClient()
+	_, err := client.sendRequest(&TwitterAPIURL{AccessMethod: "patch", URL: "http://example.com"}, &url.Values{})
+	if err == nil || err.Error() != "unsupported access method \"patch\"" {
+		t.Errorf("Expecting unsupported access method error, got %v", err)
+	}
+}
+
+func TestRestBodyJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"body":  string(body),
+			"query": r.URL.RawQuery,
+		})
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.Authenticate(&StaticTokens{App: testAppToken, User: testAppToken})
+
+	testurl := &TwitterAPIURL{
+		AccessMethod: "post",
+		BodyEncoding: "json",
+		URL:          ts.URL,
+	}
+	data := map[string]string{}
+	go client.RestBody(&data, testurl, &url.Values{"x": {"1"}}, map[string]string{"type": "message_create"})
+
+	select {
+	case err := <-client.Errors:
+		t.Fatal(err)
+	case <-client.Finished:
+	case <-time.After(time.Second):
+		t.Fatal("Data not received on Finished channel")
+	}
+	if data["body"] != `{"type":"message_create"}` || data["query"] != "x=1" {
+		t.Errorf("Unexpected request %v", data)
+	}
+}
+
+func TestNewRequestMultipart(t *testing.T) {
+	body := &MultipartBody{
+		Fields: url.Values{"media_category": {"tweet_image"}},
+		Files:  []MultipartFile{{Field: "media", Filename: "cat.png", Content: []byte("png data")}},
+	}
+	req, _, err := newRequest(&TwitterAPIURL{AccessMethod: "post", BodyEncoding: "multipart", URL: "https://upload.twitter.com/1.1/media/upload.json"}, url.Values{}, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	if req.FormValue("media_category") != "tweet_image" {
+		t.Errorf("Expecting media_category field, got %v", req.MultipartForm.Value)
+	}
+	f, h, err := req.FormFile("media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(f)
+	if h.Filename != "cat.png" || string(content) != "png data" {
+		t.Errorf("Unexpected file %v: %s", h.Filename, content)
+	}
+}
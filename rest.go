@@ -8,9 +8,21 @@ package streamingtwitter
 
 import (
 	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
 	"net/url"
 )
 
+var (
+	// Returns the authenticated user - https://dev.twitter.com/docs/api/1.1/get/account/verify_credentials
+	verifyCredentialsURL = &TwitterAPIURL{
+		AccessMethod: "get",
+		URL:          "https://api.twitter.com/1.1/account/verify_credentials.json",
+		Type:         "rest",
+	}
+)
+
 // Rest sends a REST request to Twitter's REST API:  https://dev.twitter.com/docs/api/1.1
 /*
  args := &url.Values{}
@@ -29,7 +41,21 @@ import (
  }
 */
 func (s *StreamClient) Rest(data interface{}, stream *TwitterAPIURL, formValues *url.Values) {
-	resp, err := s.sendRequest(stream, formValues)
+	s.RestBody(data, stream, formValues, nil)
+}
+
+// RestBody sends a REST request with a json or multipart body (see TwitterAPIURL.BodyEncoding).
+// body is marshaled to JSON, or must be a *MultipartBody.  formValues are sent in the query string.
+/*
+ dm := &streamingtwitter.TwitterAPIURL{
+  AccessMethod: "post",
+  BodyEncoding: "json",
+  URL:          "https://api.twitter.com/1.1/direct_messages/events/new.json",
+ }
+ go client.RestBody(&data, dm, &url.Values{}, event)
+*/
+func (s *StreamClient) RestBody(data interface{}, stream *TwitterAPIURL, formValues *url.Values, body interface{}) {
+	resp, err := s.sendRequestBody(stream, formValues, body)
 	if err != nil {
 		s.Errors <- err
 		return
@@ -39,8 +65,15 @@ func (s *StreamClient) Rest(data interface{}, stream *TwitterAPIURL, formValues
 		s.Finished <- struct{}{}
 	}()
 
+	// Some requests (e.g. deletes) succeed without any content
+	if resp.StatusCode == http.StatusNoContent {
+		return
+	}
 	err = json.NewDecoder(resp.Body).Decode(&data)
-	if err != nil {
+	if err == io.EOF {
+		return
+	} else if err != nil {
+		logEvent(s.Logger, slog.LevelWarn, "response not decoded", "url", stream.URL, "error", err)
 		s.Errors <- err
 		return
 	}
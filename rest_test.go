@@ -9,6 +9,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"testing"
@@ -165,3 +166,29 @@ func (c CloseCalled) Close() error {
 	c.Y <- struct{}{}
 	return nil
 }
+
+func TestRestNoContent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.Authenticate(&StaticTokens{App: testAppToken, User: testAppToken})
+	for _, method := range []string{"delete", "post"} {
+		testurl := &TwitterAPIURL{
+			AccessMethod: method,
+			URL:          ts.URL,
+		}
+		go client.Rest(&struct{}{}, testurl, &url.Values{"id": {"1"}})
+		select {
+		case err := <-client.Errors:
+			t.Errorf("%v: unexpected error %v", method, err)
+		case <-client.Finished:
+		case <-time.After(time.Second):
+			t.Fatalf("%v: request timeout", method)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"github.com/garyburd/go-oauth/oauth"
+	"os"
+)
+
+// StaticTokens is a Tokener for app and user tokens which are already known.  It never
+// prompts the user or writes to disk.
+type StaticTokens struct {
+	// Token for the actual application
+	App *oauth.Credentials
+	// Token for the user of the application
+	User *oauth.Credentials
+}
+
+// EnvTokens is a Tokener which reads the app and user tokens from environment variables.
+// Each field names the variable to read, the defaults are TWITTER_APP_TOKEN,
+// TWITTER_APP_SECRET, TWITTER_USER_TOKEN and TWITTER_USER_SECRET.
+type EnvTokens struct {
+	AppToken   string
+	AppSecret  string
+	UserToken  string
+	UserSecret string
+}
+
+// Token returns the user's token.
+func (t *StaticTokens) Token(oc *oauth.Client) (*oauth.Credentials, error) {
+	if err := checkAppToken(t.App); err != nil {
+		return nil, err
+	}
+
+	if t.User == nil || t.User.Token == "" || t.User.Secret == "" {
+		return nil, &ClientTokensError{
+			Msg: "missing user's Token or Secret",
+		}
+	}
+	oc.Credentials = *t.App
+
+	return t.User, nil
+}
+
+// Token returns the user's token as read from the environment.
+func (t *EnvTokens) Token(oc *oauth.Client) (*oauth.Credentials, error) {
+	app, user := &oauth.Credentials{}, &oauth.Credentials{}
+	vars := []struct {
+		v    *string
+		name string
+		def  string
+	}{
+		{&app.Token, t.AppToken, "TWITTER_APP_TOKEN"},
+		{&app.Secret, t.AppSecret, "TWITTER_APP_SECRET"},
+		{&user.Token, t.UserToken, "TWITTER_USER_TOKEN"},
+		{&user.Secret, t.UserSecret, "TWITTER_USER_SECRET"},
+	}
+	for _, e := range vars {
+		name := e.name
+		if name == "" {
+			name = e.def
+		}
+		if *e.v = os.Getenv(name); *e.v == "" {
+			return nil, &ClientTokensError{
+				Msg: "environment variable " + name + " is not set",
+			}
+		}
+	}
+
+	return (&StaticTokens{App: app, User: user}).Token(oc)
+}
@@ -0,0 +1,63 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"github.com/garyburd/go-oauth/oauth"
+	"testing"
+)
+
+func TestStaticTokens(t *testing.T) {
+	oc := &oauth.Client{}
+	tokens := &StaticTokens{
+		App: testAppToken,
+		User: &oauth.Credentials{
+			Token:  "user-token",
+			Secret: "user-secret",
+		},
+	}
+
+	token, err := tokens.Token(oc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "user-token" || token.Secret != "user-secret" {
+		t.Errorf("Unexpected user token %+v", token)
+	}
+	if oc.Credentials != *testAppToken {
+		t.Error("App token not set on the oauth client")
+	}
+
+	tokens.User = nil
+	if _, err := tokens.Token(oc); err == nil || err.Error() != "missing user's Token or Secret" {
+		t.Errorf("Expecting error \"missing user's Token or Secret\", got %v", err)
+	}
+	tokens.App = nil
+	if _, err := tokens.Token(oc); err == nil || err.Error() != "missing \"App\" token" {
+		t.Errorf("Expecting error \"missing \"App\" token\", got %v", err)
+	}
+}
+
+func TestEnvTokens(t *testing.T) {
+	t.Setenv("TWITTER_APP_TOKEN", "app-token")
+	t.Setenv("TWITTER_APP_SECRET", "app-secret")
+	t.Setenv("TWITTER_USER_TOKEN", "user-token")
+	t.Setenv("BOT_USER_SECRET", "user-secret")
+
+	oc := &oauth.Client{}
+	token, err := (&EnvTokens{UserSecret: "BOT_USER_SECRET"}).Token(oc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "user-token" || token.Secret != "user-secret" {
+		t.Errorf("Unexpected user token %+v", token)
+	}
+	if oc.Credentials != *testAppToken {
+		t.Error("App token not set on the oauth client")
+	}
+
+	_, err = (&EnvTokens{}).Token(oc)
+	if err == nil || err.Error() != "environment variable TWITTER_USER_SECRET is not set" {
+		t.Errorf("Expecting missing environment variable error, got %v", err)
+	}
+}
@@ -8,11 +8,22 @@ package streamingtwitter
 
 import (
 	"encoding/json"
+	"log/slog"
 	"net"
+	"net/http"
 	"net/url"
+	"sync"
+	"time"
 )
 
 var (
+	// Recycled statuses for clients using PoolStatuses
+	statusPool = sync.Pool{
+		New: func() interface{} {
+			return new(TwitterStatus)
+		},
+	}
+
 	// Streams is a map of known Twitter Streaming API URLs.
 	/* @todo implement fully */
 	Streams = make(map[string]*TwitterAPIURL)
@@ -56,39 +67,227 @@ func init() {
 	}
 */
 func (s *StreamClient) Stream(tweets chan<- *TwitterStatus, stream *TwitterAPIURL, formValues *url.Values) {
-	resp, err := s.sendRequest(stream, formValues)
+	activity := s.activity.get(stream, *formValues)
+	values := s.backfill(activity, *formValues)
+	s.metrics.connecting(activity.reconnecting())
+	resp, err := s.sendRequest(stream, &values)
 	if err != nil {
+		s.metrics.requestFailed(err)
 		s.Errors <- err
 		return
 	}
+	resp.Body = s.metrics.countBytes(resp.Body)
+	logEvent(s.Logger, slog.LevelInfo, "stream connected", "url", stream.URL, "count", values.Get("count"))
+	s.openStream(resp)
 	defer func() {
-		resp.Body.Close()
+		s.closeStream(resp)
 		s.Finished <- struct{}{}
 	}()
 
-	status := new(TwitterStatus)
+	// Send tweets via a buffer, which is drained before Finished is sent
+	var queue *statusQueue
+	if s.Backpressure.Size > 0 {
+		queue = newStatusQueue(s.Backpressure, s.queueStats)
+		queue.logger = s.Logger
+		done := make(chan struct{})
+		go func() {
+			queue.forward(tweets, s.Errors)
+			close(done)
+		}()
+		defer func() {
+			queue.close()
+			<-done
+		}()
+	}
+
+	if s.DecodeWorkers > 1 {
+		if err := s.decodeParallel(resp.Body, activity, tweets, queue); err != nil {
+			s.disconnected(stream, err)
+		}
+		return
+	}
+
 	decoder := json.NewDecoder(resp.Body)
 	for {
 		// @todo handle these: https://dev.twitter.com/docs/streaming-apis/messages
 		// @todo Handle stall_warnings if the option is set
 		// @todo Handle fragmented JSON, (delimited)
 
-		if err := decoder.Decode(&status); err != nil {
+		// Every message is decoded into its own status, as consumers may keep hold of them
+		status := s.newStatus()
+		offset := decoder.InputOffset()
+		if err := decoder.Decode(status); err != nil {
+			status.Release()
 			if rerr, ok := err.(*net.OpError); ok {
-				s.Errors <- rerr
+				s.disconnected(stream, rerr)
 				return
 			} else if err.Error() == "EOF" {
-				s.Errors <- err
+				s.disconnected(stream, err)
 				return
 			} else if err.Error() == "unexpected EOF" {
-				// Reconnection is left up to the client.
-				s.Errors <- err
+				s.disconnected(stream, err)
+				return
+			} else if decoder.InputOffset() == offset {
+				// Read and syntax errors (e.g. from a closed stream) are returned again by
+				// every Decode, only unmarshaling errors skip past the message.
+				s.disconnected(stream, err)
 				return
 			}
+			logEvent(s.Logger, slog.LevelWarn, "message not decoded", "url", stream.URL, "error", err)
+			s.metrics.decodeError()
 			s.Errors <- err
 			continue
 		}
+		s.deliver(activity, status, tweets, queue)
+	}
+}
+
+// Reports the error which ended a stream.  Reconnection is left up to the client.
+func (s *StreamClient) disconnected(stream *TwitterAPIURL, err error) {
+	logEvent(s.Logger, slog.LevelInfo, "stream disconnected", "url", stream.URL, "error", err)
+	s.metrics.disconnected(err)
+	s.Errors <- err
+}
+
+// Sends a decoded stream message on to the consumer.
+func (s *StreamClient) deliver(activity *streamActivity, status *TwitterStatus, tweets chan<- *TwitterStatus, queue *statusQueue) {
+	// Stream messages which aren't tweets have no ID
+	if status.ID == "" {
+		if notice := withheldNotice(status.Raw()); notice != nil {
+			s.metrics.message(notice.Type+"_withheld", nil, time.Now())
+			status.Release()
+			if s.Withheld != nil {
+				s.Withheld <- notice
+			} else {
+				logEvent(s.Logger, slog.LevelDebug, "withheld notice discarded", "type", notice.Type, "id", notice.ID)
+			}
+			return
+		}
+		kind := NewStatusView(status.Raw()).Kind()
+		if kind == "" {
+			kind = "unknown"
+		}
+		s.metrics.message(kind, nil, time.Now())
+	} else {
+		now := time.Now()
+		activity.received(now)
+		s.metrics.message("status", status, now)
+	}
 
+	if queue == nil {
 		tweets <- status
+		return
+	}
+	if err := queue.push(status); err != nil {
+		s.Errors <- err
+	}
+}
+
+// Records an open stream, so CloseStreams can close it.
+func (s *StreamClient) openStream(resp *http.Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams == nil {
+		s.streams = make(map[*http.Response]bool)
+	}
+	s.streams[resp] = true
+	if s.closed {
+		// Connected after the client was shut down
+		resp.Body.Close()
+	}
+}
+
+// Closes a stream's body once it has finished.
+func (s *StreamClient) closeStream(resp *http.Response) {
+	s.mu.Lock()
+	delete(s.streams, resp)
+	s.mu.Unlock()
+	resp.Body.Close()
+}
+
+// CloseStreams disconnects all of the client's open streams, including those opened by
+// StreamPartitions.  Each stream then sends the resulting read error on Errors and
+// finishes as usual.
+func (s *StreamClient) CloseStreams() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for resp := range s.streams {
+		resp.Body.Close()
+	}
+	for clone := range s.clones {
+		clone.shutdown()
+	}
+}
+
+// Records a clone streaming on the client's behalf, so CloseStreams reaches its streams.
+func (s *StreamClient) addClone(clone *StreamClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clones == nil {
+		s.clones = make(map[*StreamClient]bool)
+	}
+	s.clones[clone] = true
+}
+
+func (s *StreamClient) removeClone(clone *StreamClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clones, clone)
+}
+
+// Closes the client's open streams, and any it connects from now on.  Used to stop the
+// clones which stream on another client's behalf, as they may still be connecting.
+func (s *StreamClient) shutdown() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.CloseStreams()
+}
+
+// Returns a client sharing the credentials and settings of s, with its own Errors and
+// Finished channels.
+func (s *StreamClient) clone() *StreamClient {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &StreamClient{
+		oauthClient:   s.oauthClient,
+		token:         s.token,
+		tokener:       s.tokener,
+		httpClient:    s.httpClient,
+		middleware:    s.middleware,
+		bearer:        s.bearer,
+		activity:      s.activity,
+		metrics:       s.metrics,
+		queueStats:    s.queueStats,
+		Errors:        make(chan error),
+		Finished:      make(chan struct{}),
+		Withheld:      s.Withheld,
+		Backpressure:  s.Backpressure,
+		PoolStatuses:  s.PoolStatuses,
+		DecodeWorkers: s.DecodeWorkers,
+		PreserveOrder: s.PreserveOrder,
+		BackfillLimit: s.BackfillLimit,
+		Gzip:          s.Gzip,
+		Logger:        s.Logger,
+	}
+}
+
+// Returns an empty status to decode a stream message into.
+func (s *StreamClient) newStatus() *TwitterStatus {
+	if !s.PoolStatuses {
+		return new(TwitterStatus)
+	}
+	status := statusPool.Get().(*TwitterStatus)
+	*status = TwitterStatus{pooled: true}
+	return status
+}
+
+// Release hands the status back to be reused for a later message, when the client's
+// PoolStatuses is enabled.  The status must not be used after it is released.  For
+// statuses which didn't come from the pool this does nothing.
+func (ts *TwitterStatus) Release() {
+	if ts.pooled {
+		ts.pooled = false
+		statusPool.Put(ts)
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"errors"
 	"github.com/garyburd/go-oauth/oauth"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -103,8 +104,8 @@ func TestTweetCreation(t *testing.T) {
 		{"FavoriteCount", status.FavoriteCount, uint32(0)},
 		// TwitterCoordinate
 		{"Coordinates.Type", status.Coordinates.Type, "Point"},
-		{"Coordinates.Coordinates[0]", status.Coordinates.Coordinates[0], -74.210251},
-		{"Coordinates.Coordinates[1]", status.Coordinates.Coordinates[1], 40.422551},
+		{"Coordinates.Coordinates.Lng", status.Coordinates.Coordinates.Lng, -74.210251},
+		{"Coordinates.Coordinates.Lat", status.Coordinates.Coordinates.Lat, 40.422551},
 		// TwitterPlace
 		{"Place.ID", status.Place.ID, "27485069891a7938"},
 		{"Place.URL", status.Place.URL, "https://api.twitter.com/1.1/geo/id/27485069891a7938.json"},
@@ -114,10 +115,10 @@ func TestTweetCreation(t *testing.T) {
 		{"Place.CountryCode", status.Place.CountryCode, "US"},
 		{"Place.Country", status.Place.Country, "United States"},
 		{"Place.BoundingBox.Type", status.Place.BoundingBox.Type, "Polygon"},
-		{"Place.BoundingBox.Coordinates[0].([]interface{})[0].([]interface{})[0]", status.Place.BoundingBox.Coordinates[0].([]interface{})[0].([]interface{})[0], -74.04725},
-		{"Place.BoundingBox.Coordinates[0].([]interface{})[0].([]interface{})[2]", status.Place.BoundingBox.Coordinates[0].([]interface{})[0].([]interface{})[1], 40.541722},
-		{"Place.BoundingBox.Coordinates[0].([]interface{})[2].([]interface{})[0]", status.Place.BoundingBox.Coordinates[0].([]interface{})[2].([]interface{})[0], -73.699793},
-		{"Place.BoundingBox.Coordinates[0].([]interface{})[2].([]interface{})[1]", status.Place.BoundingBox.Coordinates[0].([]interface{})[2].([]interface{})[1], 40.91533},
+		{"Place.BoundingBox.Coordinates[0][0].Lng", status.Place.BoundingBox.Coordinates[0][0].Lng, -74.04725},
+		{"Place.BoundingBox.Coordinates[0][0].Lat", status.Place.BoundingBox.Coordinates[0][0].Lat, 40.541722},
+		{"Place.BoundingBox.Coordinates[0][2].Lng", status.Place.BoundingBox.Coordinates[0][2].Lng, -73.699793},
+		{"Place.BoundingBox.Coordinates[0][2].Lat", status.Place.BoundingBox.Coordinates[0][2].Lat, 40.91533},
 		// TwitterEntity
 		// TwitterHashTag
 		{"Entities.Hashtags[0].Text", status.Entities.Hashtags[0].Text, "RuinAToy"},
@@ -131,7 +132,8 @@ func TestTweetCreation(t *testing.T) {
 		{"Entities.Media[0].ExpandedURL", status.Entities.Media[0].ExpandedURL, "http://twitter.com/ShawnWiora/status/468831180297887744/photo/1"},
 		{"Entities.Media[0].MediaURL", status.Entities.Media[0].MediaURL, "http://pbs.twimg.com/media/BoGfeL_IUAEcnfI.jpg"},
 		{"Entities.Media[0].MediaURLHttps", status.Entities.Media[0].MediaURLHttps, "https://pbs.twimg.com/media/BoGfeL_IUAEcnfI.jpg"},
-		{"Entities.Media[0].Sizes[\"medium\"].(map[string]interface{})[\"w\"]", status.Entities.Media[0].Sizes["medium"].(map[string]interface{})["w"], float64(600)},
+		{"Entities.Media[0].Sizes.Medium.W", status.Entities.Media[0].Sizes.Medium.W, uint32(600)},
+		{"Entities.Media[0].Sizes.Thumb.Resize", status.Entities.Media[0].Sizes.Thumb.Resize, "crop"},
 		{"Entities.Media[0].Indices", status.Entities.Media[0].Indices[0], uint(113)},
 		{"Entities.Media[0].Indices", status.Entities.Media[0].Indices[1], uint(135)},
 		// TwitterUrl
@@ -155,6 +157,57 @@ func TestTweetCreation(t *testing.T) {
 	}
 }
 
+func TestTweetRawAndExtraFields(t *testing.T) {
+	cf, err := ioutil.ReadFile("test_data/tweet.json")
+	if err != nil {
+		t.Fatal("Unable to open test data file")
+	}
+	handler := func(*http.Client, *oauth.Credentials, string, url.Values) (*http.Response, error) {
+		resp := &http.Response{
+			Body: ioutil.NopCloser(bytes.NewBuffer(cf)),
+		}
+		return resp, nil
+	}
+
+	testurl := &TwitterAPIURL{
+		AccessMethod:  "custom",
+		CustomHandler: handler,
+	}
+
+	client := NewClient()
+	status := new(TwitterStatus)
+	tweets := make(chan *TwitterStatus)
+	go client.Stream(tweets, testurl, &url.Values{})
+	select {
+	case status = <-tweets:
+		break
+	case <-time.After(2 * time.Millisecond):
+		t.Fatal("Tweet data not receieved")
+	}
+
+	if !bytes.Equal(status.Raw(), bytes.TrimSpace(cf)) {
+		t.Error("Raw message does not match the received data")
+	}
+
+	extra, err := status.Extra()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"contributors", "filter_level", "geo", "id"} {
+		if _, ok := extra[k]; !ok {
+			t.Errorf("Expecting extra field %v", k)
+		}
+	}
+	for _, k := range []string{"id_str", "user", "entities"} {
+		if _, ok := extra[k]; ok {
+			t.Errorf("Not expecting modelled field %v in extra fields", k)
+		}
+	}
+	if string(extra["filter_level"]) != "\"medium\"" {
+		t.Errorf("Expecting filter_level \"medium\", got %s", extra["filter_level"])
+	}
+}
+
 func TestDefaultStreamVariablesExist(t *testing.T) {
 	_, ok := Streams["Filter"]
 	if ok != true {
@@ -303,3 +356,109 @@ func TestNetworkErrorReturns(t *testing.T) {
 		t.Error("Error not received on Errors channel")
 	}
 }
+
+func TestStreamStatusesAreIndependent(t *testing.T) {
+	handler := func(*http.Client, *oauth.Credentials, string, url.Values) (*http.Response, error) {
+		resp := &http.Response{
+			Body: ioutil.NopCloser(bytes.NewBufferString("{\"id_str\":\"1\",\"text\":\"first\",\"lang\":\"en\"}\r\n{\"id_str\":\"2\",\"text\":\"second\"}\r\n")),
+		}
+		return resp, nil
+	}
+
+	testurl := &TwitterAPIURL{
+		AccessMethod:  "custom",
+		CustomHandler: handler,
+	}
+
+	for _, pool := range []bool{false, true} {
+		client := NewClient()
+		client.PoolStatuses = pool
+		tweets := make(chan *TwitterStatus)
+		go client.Stream(tweets, testurl, &url.Values{})
+
+		received := []*TwitterStatus{}
+	Receive:
+		for {
+			select {
+			case status := <-tweets:
+				received = append(received, status)
+			case err := <-client.Errors:
+				if err.Error() != "EOF" {
+					t.Fatal(err)
+				}
+			case <-client.Finished:
+				break Receive
+			case <-time.After(time.Second):
+				t.Fatal("Stream timeout")
+			}
+		}
+
+		if len(received) != 2 {
+			t.Fatalf("pool %v: expecting 2 statuses, got %v", pool, len(received))
+		}
+		first, second := received[0], received[1]
+		if first == second {
+			t.Errorf("pool %v: same status sent twice", pool)
+		}
+		if first.ID != "1" || first.Text != "first" || first.Language != "en" {
+			t.Errorf("pool %v: first status overwritten: %+v", pool, first)
+		}
+		if second.Language != "" {
+			t.Errorf("pool %v: stale field in second status: %q", pool, second.Language)
+		}
+		first.Release()
+		second.Release()
+	}
+}
+
+func TestReleaseResetsPooledStatus(t *testing.T) {
+	client := NewClient()
+	client.PoolStatuses = true
+	status := client.newStatus()
+	status.Text = "text"
+	status.Release()
+	// Releasing twice must not put it in the pool again
+	status.Release()
+
+	if reused := client.newStatus(); reused.Text != "" || !reused.pooled {
+		t.Errorf("Expecting an empty pooled status, got %+v", reused)
+	}
+	if status := NewClient().newStatus(); status.pooled {
+		t.Error("Status pooled without PoolStatuses")
+	}
+}
+
+func TestReadErrorReturns(t *testing.T) {
+	handler := func(*http.Client, *oauth.Credentials, string, url.Values) (*http.Response, error) {
+		r, w := io.Pipe()
+		go func() {
+			w.Write([]byte("{\"id_str\":\"1\"}\r\n"))
+			w.CloseWithError(errors.New("connection reset"))
+		}()
+		return &http.Response{Body: r}, nil
+	}
+
+	testurl := &TwitterAPIURL{
+		AccessMethod:  "custom",
+		CustomHandler: handler,
+	}
+
+	client := NewClient()
+	tweets := make(chan *TwitterStatus)
+	go client.Stream(tweets, testurl, &url.Values{})
+	errs := 0
+	for {
+		select {
+		case <-tweets:
+		case <-client.Errors:
+			errs++
+		case <-client.Finished:
+			if errs != 1 {
+				t.Errorf("Expecting 1 error, got %v", errs)
+			}
+			return
+		case <-time.After(time.Second):
+			t.Fatal("Stream did not finish after a read error")
+		}
+	}
+}
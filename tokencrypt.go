@@ -0,0 +1,152 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// Key derivation used for encrypted token files
+	tokenFileKDF           = "pbkdf2-sha256"
+	tokenFileKDFIterations = 600000
+	tokenFileSaltSize      = 16
+	// Most iterations accepted from a token file, leaving room to raise the default
+	// without a damaged file making Token hang
+	tokenFileMaxKDFIterations = 4 * tokenFileKDFIterations
+)
+
+// Format of an encrypted token file.  Data is the AES-256-GCM encrypted JSON of an
+// unencrypted token file.
+type encryptedTokenFile struct {
+	Encrypted *encryptedTokens
+}
+
+type encryptedTokens struct {
+	KDF        string
+	Iterations int
+	Salt       []byte
+	Nonce      []byte
+	Data       []byte
+}
+
+// Encrypt rewrites the token file encrypted with the Passphrase (or KeyFile).  Use this
+// to migrate an existing plaintext token file.
+func (t *ClientTokens) Encrypt() error {
+	if !t.encrypted() {
+		return &ClientTokensError{
+			Msg: "no passphrase or key file supplied",
+		}
+	}
+	return t.update(func() {})
+}
+
+// Whether the token file should be encrypted.
+func (t *ClientTokens) encrypted() bool {
+	return t.Passphrase != "" || t.KeyFile != ""
+}
+
+// Returns the secret the encryption key is derived from.
+func (t *ClientTokens) secret() (string, error) {
+	if t.KeyFile == "" {
+		return t.Passphrase, nil
+	}
+	key, err := ioutil.ReadFile(t.KeyFile)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// Decrypts the token file contents if they are encrypted.  Plaintext contents are
+// returned unchanged.
+func (t *ClientTokens) decrypt(cf []byte) ([]byte, error) {
+	var file encryptedTokenFile
+	if err := json.Unmarshal(cf, &file); err != nil || file.Encrypted == nil {
+		return cf, nil
+	}
+	enc := file.Encrypted
+
+	if !t.encrypted() {
+		return nil, &ClientTokensError{
+			Msg: "token file is encrypted, no passphrase or key file supplied",
+		}
+	}
+	if enc.KDF != tokenFileKDF {
+		return nil, &ClientTokensError{
+			Msg: "unsupported token file key derivation: " + enc.KDF,
+		}
+	}
+
+	if enc.Iterations <= 0 || enc.Iterations > tokenFileMaxKDFIterations {
+		return nil, &ClientTokensError{
+			Msg: "invalid token file key derivation iterations",
+		}
+	}
+
+	aead, err := t.cipher(enc.Salt, enc.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(enc.Nonce) != aead.NonceSize() {
+		return nil, &ClientTokensError{
+			Msg: "invalid token file nonce",
+		}
+	}
+	data, err := aead.Open(nil, enc.Nonce, enc.Data, nil)
+	if err != nil {
+		return nil, &ClientTokensError{
+			Msg: "unable to decrypt token file (incorrect passphrase or key file?)",
+		}
+	}
+	return data, nil
+}
+
+// Encrypts the token file contents.
+func (t *ClientTokens) encrypt(data []byte) ([]byte, error) {
+	enc := &encryptedTokens{
+		KDF:        tokenFileKDF,
+		Iterations: tokenFileKDFIterations,
+		Salt:       make([]byte, tokenFileSaltSize),
+	}
+	if _, err := io.ReadFull(rand.Reader, enc.Salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := t.cipher(enc.Salt, enc.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	enc.Nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, enc.Nonce); err != nil {
+		return nil, err
+	}
+	enc.Data = aead.Seal(nil, enc.Nonce, data, nil)
+
+	return json.Marshal(&encryptedTokenFile{enc})
+}
+
+// Returns an AES-256-GCM cipher keyed from the passphrase (or key file).
+func (t *ClientTokens) cipher(salt []byte, iterations int) (cipher.AEAD, error) {
+	secret, err := t.secret()
+	if err != nil {
+		return nil, err
+	}
+	key, err := pbkdf2.Key(sha256.New, secret, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
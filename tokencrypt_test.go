@@ -0,0 +1,96 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"bytes"
+	"github.com/garyburd/go-oauth/oauth"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestTokenFileEncryption(t *testing.T) {
+	file := newTestTokenFile(t)
+	err := (&ClientTokens{TokenFile: file}).AddProfile("bot", &TokenProfile{
+		Credentials: oauth.Credentials{Token: "user-token", Secret: "user-secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrate the plaintext file
+	if err := (&ClientTokens{TokenFile: file, Passphrase: "secret"}).Encrypt(); err != nil {
+		t.Fatal(err)
+	}
+	cf, _ := ioutil.ReadFile(file)
+	if bytes.Contains(cf, []byte("app-secret")) || bytes.Contains(cf, []byte("user-secret")) {
+		t.Error("Token file is not encrypted")
+	}
+
+	token, err := (&ClientTokens{TokenFile: file, Passphrase: "secret", Profile: "bot"}).Token(&oauth.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "user-token" || token.Secret != "user-secret" {
+		t.Errorf("Unexpected user token %+v", token)
+	}
+
+	_, err = (&ClientTokens{TokenFile: file, Passphrase: "wrong"}).Token(&oauth.Client{})
+	if _, ok := err.(*ClientTokensError); !ok {
+		t.Errorf("Expecting ClientTokensError for an incorrect passphrase, got %v", err)
+	}
+	_, err = (&ClientTokens{TokenFile: file}).Token(&oauth.Client{})
+	if err == nil || err.Error() != "token file is encrypted, no passphrase or key file supplied" {
+		t.Errorf("Expecting missing passphrase error, got %v", err)
+	}
+}
+
+func TestTokenFileEncryptionKeyFile(t *testing.T) {
+	file := newTestTokenFile(t)
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := ioutil.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := &ClientTokens{TokenFile: file, KeyFile: keyFile}
+	if err := tokens.Encrypt(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.AddProfile("bot", &TokenProfile{}); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := (&ClientTokens{TokenFile: file, KeyFile: keyFile}).ListProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "bot" {
+		t.Errorf("Expecting profile bot, got %v", names)
+	}
+
+	if err := (&ClientTokens{TokenFile: file}).Encrypt(); err == nil {
+		t.Error("Expecting error encrypting without a passphrase or key file")
+	}
+}
+
+func TestTokenFileEncryptionCorrupt(t *testing.T) {
+	testData := []struct {
+		n    string
+		file string
+	}{
+		{"truncated nonce", `{"Encrypted":{"KDF":"pbkdf2-sha256","Iterations":1,"Salt":"c2FsdA==","Nonce":"bm9uY2U=","Data":"ZGF0YQ=="}}`},
+		{"no iterations", `{"Encrypted":{"KDF":"pbkdf2-sha256","Iterations":0,"Salt":"c2FsdA==","Nonce":"AAAAAAAAAAAAAAAA","Data":"ZGF0YQ=="}}`},
+		{"too many iterations", `{"Encrypted":{"KDF":"pbkdf2-sha256","Iterations":2147483647,"Salt":"c2FsdA==","Nonce":"AAAAAAAAAAAAAAAA","Data":"ZGF0YQ=="}}`},
+	}
+	for _, d := range testData {
+		file := filepath.Join(t.TempDir(), "tokens.json")
+		if err := ioutil.WriteFile(file, []byte(d.file), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := (&ClientTokens{TokenFile: file, Passphrase: "secret"}).Token(&oauth.Client{})
+		if _, ok := err.(*ClientTokensError); !ok {
+			t.Errorf("%v: expecting ClientTokensError, got %v", d.n, err)
+		}
+	}
+}
@@ -4,9 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/garyburd/go-oauth/oauth"
+	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 )
 
 var (
@@ -21,6 +29,62 @@ type ClientTokens struct {
 	App *oauth.Credentials
 	// Token for the user of the application
 	User *oauth.Credentials
+	// Named user tokens, for when several accounts use the same app
+	Profiles map[string]*TokenProfile `json:",omitempty"`
+	// Name of the profile to use instead of User
+	Profile string `json:"-"`
+	// Passphrase, or file containing a key, used to encrypt the token file.  When both
+	// are empty the token file is stored as plain JSON.
+	Passphrase string `json:"-"`
+	KeyFile    string `json:"-"`
+
+	// Fields within the token file which aren't known to ClientTokens
+	extra map[string]json.RawMessage
+	// Asks the user to authorize the app when no user token is available (defaults to the terminal)
+	Prompter Prompter `json:"-"`
+	// Receives events when tokens are requested, saved or revoked (silent when nil)
+	Logger Logger `json:"-"`
+}
+
+// A Prompter sends the user to Twitter's authorization URL and returns the verification
+// code (PIN) they were given.
+type Prompter interface {
+	Prompt(authURL string) (verifier string, err error)
+}
+
+// PrompterFunc allows an ordinary function to be used as a Prompter.
+type PrompterFunc func(authURL string) (string, error)
+
+// Prompt calls f(authURL).
+func (f PrompterFunc) Prompt(authURL string) (string, error) {
+	return f(authURL)
+}
+
+// TerminalPrompter writes the authorization instructions to Out and reads the
+// verification code from In.
+type TerminalPrompter struct {
+	In  io.Reader
+	Out io.Writer
+}
+
+// Prompt asks for the verification code on the terminal.
+func (p *TerminalPrompter) Prompt(authURL string) (string, error) {
+	fmt.Fprintf(p.Out, "Before we can continue ...\nGo to:\n\n\t%s\n\nAuthorize the application and enter in the verification code: ", authURL)
+
+	var authCode string
+	if _, err := fmt.Fscanln(p.In, &authCode); err != nil {
+		return "", err
+	}
+	return authCode, nil
+}
+
+// TokenProfile is a named user token stored within the token file.
+type TokenProfile struct {
+	oauth.Credentials
+	ScreenName string
+	UserID     string
+	// When the token was obtained
+	Obtained time.Time
 }
 
 type ClientTokensError struct {
@@ -33,70 +97,263 @@ func (e ClientTokensError) Error() string {
 
 // You get a token for your App from Twitter.  Put this within the App section
 // of the  JSON token file.  The user's token will be requested, then written
-// and saved to this file.
+// and saved to this file.  When Profile is set, the named profile's token is used
+// (and requested if it doesn't exist yet) instead of User.
 func (t *ClientTokens) Token(oc *oauth.Client) (*oauth.Credentials, error) {
-	if t.TokenFile == "" {
-		return nil, &ClientTokensError{
-			Msg: "no token file supplied",
-		}
-	}
-
-	cf, err := ioutil.ReadFile(t.TokenFile)
-	if err != nil {
+	if err := t.load(); err != nil {
 		return nil, err
 	}
-	if err := json.Unmarshal(cf, t); err != nil {
-		return nil, err
-	}
-
-	if t.App == nil {
-		return nil, &ClientTokensError{
-			Msg: "missing \"App\" token",
-		}
-	}
 
-	if t.App.Token == "" || t.App.Secret == "" {
-		return nil, &ClientTokensError{
-			Msg: "missing app's Token or Secret",
-		}
+	if err := checkAppToken(t.App); err != nil {
+		return nil, err
 	}
 	oc.Credentials = *t.App
 
-	var token *oauth.Credentials
-	if t.User == nil {
-		token = &oauth.Credentials{}
-	} else {
+	token := &oauth.Credentials{}
+	if t.Profile != "" {
+		if p, ok := t.Profiles[t.Profile]; ok && p != nil {
+			token = &p.Credentials
+		}
+	} else if t.User != nil {
 		token = t.User
 	}
 
 	if token.Token == "" || token.Secret == "" {
+		logEvent(t.Logger, slog.LevelInfo, "requesting user token", "profile", t.Profile)
 		tempCredentials, err := oc.RequestTemporaryCredentials(http.DefaultClient, "oob", nil)
 		if err != nil {
+			logEvent(t.Logger, slog.LevelError, "temporary credentials request failed", "error", err)
 			return nil, err
 		}
 
-		url := oc.AuthorizationURL(tempCredentials, nil)
-		fmt.Fprintf(os.Stdout, "Before we can continue ...\nGo to:\n\n\t%s\n\nAuthorize the application and enter in the verification code: ", url)
-
-		var authCode string
-		fmt.Scanln(&authCode)
-
-		token, _, err = oc.RequestToken(http.DefaultClient, tempCredentials, authCode)
+		prompter := t.Prompter
+		if prompter == nil {
+			prompter = &TerminalPrompter{In: os.Stdin, Out: os.Stdout}
+		}
+		authCode, err := prompter.Prompt(oc.AuthorizationURL(tempCredentials, nil))
 		if err != nil {
 			return nil, err
 		}
 
-		// Save the user token within our token file
-		t.User = token
-		save, err := json.Marshal(t)
+		var values url.Values
+		token, values, err = oc.RequestToken(http.DefaultClient, tempCredentials, authCode)
 		if err != nil {
+			logEvent(t.Logger, slog.LevelError, "user token request failed", "error", err)
 			return nil, err
 		}
 
-		if err := ioutil.WriteFile(t.TokenFile, save, tokenFilePermission); err != nil {
+		// Save the user token within our token file
+		err = t.update(func() {
+			if t.Profile != "" {
+				if t.Profiles == nil {
+					t.Profiles = make(map[string]*TokenProfile)
+				}
+				t.Profiles[t.Profile] = &TokenProfile{
+					Credentials: *token,
+					ScreenName:  values.Get("screen_name"),
+					UserID:      values.Get("user_id"),
+					Obtained:    time.Now().UTC(),
+				}
+			} else {
+				t.User = token
+			}
+		})
+		if err != nil {
 			return nil, err
 		}
+		logEvent(t.Logger, slog.LevelInfo, "user token saved", "profile", t.Profile, "screen_name", values.Get("screen_name"))
 	}
 
 	return token, nil
 }
+
+// Revoke removes a user token which Twitter no longer accepts from the token file, so
+// the next call to Token requests a new one.
+func (t *ClientTokens) Revoke(token *oauth.Credentials) error {
+	logEvent(t.Logger, slog.LevelInfo, "revoking user token", "profile", t.Profile)
+	return t.update(func() {
+		if t.Profile != "" {
+			// Another process may have already replaced the token
+			if p, ok := t.Profiles[t.Profile]; ok && (token == nil || p.Token == token.Token) {
+				delete(t.Profiles, t.Profile)
+			}
+		} else if t.User != nil && (token == nil || t.User.Token == token.Token) {
+			t.User = nil
+		}
+	})
+}
+
+// Returns an error if the app token is missing or incomplete.
+func checkAppToken(app *oauth.Credentials) error {
+	if app == nil {
+		return &ClientTokensError{
+			Msg: "missing \"App\" token",
+		}
+	}
+
+	if app.Token == "" || app.Secret == "" {
+		return &ClientTokensError{
+			Msg: "missing app's Token or Secret",
+		}
+	}
+	return nil
+}
+
+// ListProfiles returns the names of all profiles stored within the token file.
+func (t *ClientTokens) ListProfiles() ([]string, error) {
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(t.Profiles))
+	for name := range t.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// AddProfile stores (or replaces) a named profile within the token file.
+func (t *ClientTokens) AddProfile(name string, p *TokenProfile) error {
+	return t.update(func() {
+		if t.Profiles == nil {
+			t.Profiles = make(map[string]*TokenProfile)
+		}
+		t.Profiles[name] = p
+	})
+}
+
+// RemoveProfile deletes a named profile from the token file.
+func (t *ClientTokens) RemoveProfile(name string) error {
+	found := false
+	err := t.update(func() {
+		if _, found = t.Profiles[name]; found {
+			delete(t.Profiles, name)
+		}
+	})
+	if err == nil && !found {
+		return &ClientTokensError{
+			Msg: fmt.Sprintf("no profile named %q", name),
+		}
+	}
+	return err
+}
+
+// Re-reads the token file, applies fn to t and writes the result back.  The token file
+// is locked throughout, so concurrent processes don't clobber each other's changes.
+func (t *ClientTokens) update(fn func()) error {
+	if t.TokenFile == "" {
+		return &ClientTokensError{
+			Msg: "no token file supplied",
+		}
+	}
+
+	unlock, err := lockFile(t.TokenFile + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Profiles removed by other processes shouldn't be written back
+	t.Profiles = nil
+	if err := t.load(); err != nil {
+		return err
+	}
+	fn()
+	return t.save()
+}
+
+// Read the token file into t.
+func (t *ClientTokens) load() error {
+	if t.TokenFile == "" {
+		return &ClientTokensError{
+			Msg: "no token file supplied",
+		}
+	}
+
+	cf, err := ioutil.ReadFile(t.TokenFile)
+	if err != nil {
+		logEvent(t.Logger, slog.LevelError, "token file not read", "file", t.TokenFile, "error", err)
+		return err
+	}
+	if cf, err = t.decrypt(cf); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(cf, t); err != nil {
+		return err
+	}
+
+	// Keep hold of any fields we don't know about, so they are written back out
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(cf, &fields); err != nil {
+		return err
+	}
+	known := jsonFieldNames(reflect.TypeOf(*t))
+	for k := range fields {
+		if known[strings.ToLower(k)] {
+			delete(fields, k)
+		}
+	}
+	t.extra = fields
+	return nil
+}
+
+// Write t to the token file.
+func (t *ClientTokens) save() error {
+	save, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if len(t.extra) > 0 {
+		fields := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(save, &fields); err != nil {
+			return err
+		}
+		for k, v := range t.extra {
+			fields[k] = v
+		}
+		if save, err = json.Marshal(fields); err != nil {
+			return err
+		}
+	}
+	if t.encrypted() {
+		if save, err = t.encrypt(save); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(t.TokenFile, save, tokenFilePermission); err != nil {
+		logEvent(t.Logger, slog.LevelError, "token file not written", "file", t.TokenFile, "error", err)
+		return err
+	}
+	logEvent(t.Logger, slog.LevelDebug, "token file written", "file", t.TokenFile, "encrypted", t.encrypted())
+	return nil
+}
+
+// Writes data to a temporary file which is then renamed to name, so name is never left
+// partially written.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	// Clean up on failure, after a successful rename this does nothing.
+	defer os.Remove(tmp)
+
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
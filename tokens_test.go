@@ -3,10 +3,77 @@
 package streamingtwitter
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"github.com/garyburd/go-oauth/oauth"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 )
 
+// Starts a fake Twitter OAuth server and returns an oauth.Client which uses it.  The
+// authorize URL acts as a user who immediately grants access.
+func newTestOAuthServer(t *testing.T) (*httptest.Server, *oauth.Client) {
+	var mu sync.Mutex
+	callback := ""
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/request_token", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		callback = oauthParam(r, "oauth_callback")
+		mu.Unlock()
+		w.Write([]byte("oauth_token=temp-token&oauth_token_secret=temp-secret&oauth_callback_confirmed=true"))
+	})
+	mux.HandleFunc("/oauth/authorize", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		http.Redirect(w, r, callback+"?oauth_token="+r.FormValue("oauth_token")+"&oauth_verifier=1234567", http.StatusFound)
+	})
+	mux.HandleFunc("/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		if oauthParam(r, "oauth_verifier") != "1234567" {
+			http.Error(w, "invalid verifier", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("oauth_token=user-token&oauth_token_secret=user-secret&user_id=123&screen_name=gopher"))
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	return ts, &oauth.Client{
+		TemporaryCredentialRequestURI: ts.URL + "/oauth/request_token",
+		ResourceOwnerAuthorizationURI: ts.URL + "/oauth/authorize",
+		TokenRequestURI:               ts.URL + "/oauth/access_token",
+	}
+}
+
+// Returns a parameter from the request's OAuth Authorization header.
+func oauthParam(r *http.Request, name string) string {
+	for _, p := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "OAuth "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) == 2 && kv[0] == name {
+			v, _ := url.QueryUnescape(strings.Trim(kv[1], "\""))
+			return v
+		}
+	}
+	return ""
+}
+
+// Writes a token file containing only the app token and returns its location.
+func newTestTokenFile(t *testing.T) string {
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	data := []byte("{\"App\":{\"Token\":\"app-token\",\"Secret\":\"app-secret\"}}")
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
 func TestTokenAbsentTokenFileStringError(t *testing.T) {
 	tokens := &ClientTokens{}
 	_, err := tokens.Token(&oauth.Client{})
@@ -64,3 +131,232 @@ func TestTokenErrorOutput(t *testing.T) {
 		t.Errorf("Expecting \"error message\", got %v", err)
 	}
 }
+
+func TestTerminalPrompter(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := &TerminalPrompter{
+		In:  strings.NewReader("1234567\n"),
+		Out: out,
+	}
+	code, err := p.Prompt("https://api.twitter.com/oauth/authorize?oauth_token=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "1234567" {
+		t.Errorf("Expecting verification code 1234567, got %v", code)
+	}
+	if !strings.Contains(out.String(), "https://api.twitter.com/oauth/authorize?oauth_token=x") {
+		t.Errorf("Authorization URL not written, got %v", out.String())
+	}
+}
+
+func TestTokenUsesPrompter(t *testing.T) {
+	_, oc := newTestOAuthServer(t)
+	authURL := ""
+	tokens := &ClientTokens{
+		TokenFile: newTestTokenFile(t),
+		Prompter: PrompterFunc(func(u string) (string, error) {
+			authURL = u
+			return "1234567", nil
+		}),
+	}
+
+	token, err := tokens.Token(oc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, oc.ResourceOwnerAuthorizationURI) {
+		t.Errorf("Prompter not given the authorization URL, got %v", authURL)
+	}
+	if token.Token != "user-token" || token.Secret != "user-secret" {
+		t.Errorf("Unexpected user token %+v", token)
+	}
+
+	saved := &ClientTokens{}
+	cf, _ := ioutil.ReadFile(tokens.TokenFile)
+	if err := json.Unmarshal(cf, saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.User == nil || saved.User.Token != "user-token" {
+		t.Error("User token not saved to the token file")
+	}
+}
+
+func TestTokenPrompterError(t *testing.T) {
+	_, oc := newTestOAuthServer(t)
+	tokens := &ClientTokens{
+		TokenFile: newTestTokenFile(t),
+		Prompter: PrompterFunc(func(string) (string, error) {
+			return "", errors.New("prompt cancelled")
+		}),
+	}
+
+	if _, err := tokens.Token(oc); err == nil || err.Error() != "prompt cancelled" {
+		t.Errorf("Expecting error \"prompt cancelled\", got %v", err)
+	}
+}
+
+func TestTokenProfiles(t *testing.T) {
+	_, oc := newTestOAuthServer(t)
+	file := newTestTokenFile(t)
+	prompter := PrompterFunc(func(string) (string, error) {
+		return "1234567", nil
+	})
+
+	tokens := &ClientTokens{
+		TokenFile: file,
+		Profile:   "bot",
+		Prompter:  prompter,
+	}
+	token, err := tokens.Token(oc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "user-token" {
+		t.Errorf("Expecting user-token, got %v", token.Token)
+	}
+	if tokens.User != nil {
+		t.Error("Not expecting User to be set when using a profile")
+	}
+
+	err = tokens.AddProfile("other", &TokenProfile{
+		Credentials: oauth.Credentials{Token: "other-token", Secret: "other-secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reload from the file
+	tokens = &ClientTokens{
+		TokenFile: file,
+	}
+	names, err := tokens.ListProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "bot,other" {
+		t.Errorf("Expecting profiles bot,other, got %v", names)
+	}
+	bot := tokens.Profiles["bot"]
+	if bot.ScreenName != "gopher" || bot.UserID != "123" || bot.Obtained.IsZero() {
+		t.Errorf("Profile details not saved, got %+v", bot)
+	}
+
+	tokens.Profile = "other"
+	token, err = tokens.Token(&oauth.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "other-token" || token.Secret != "other-secret" {
+		t.Errorf("Unexpected profile token %+v", token)
+	}
+
+	if err := tokens.RemoveProfile("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.RemoveProfile("other"); err == nil {
+		t.Error("Expecting error removing a missing profile")
+	}
+	names, _ = (&ClientTokens{TokenFile: file}).ListProfiles()
+	if len(names) != 1 || names[0] != "bot" {
+		t.Errorf("Expecting profile bot, got %v", names)
+	}
+}
+
+func TestTokenSingleUserFileLoads(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	data := []byte("{\"App\":{\"Token\":\"app-token\",\"Secret\":\"app-secret\"},\"User\":{\"Token\":\"user-token\",\"Secret\":\"user-secret\"}}")
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := (&ClientTokens{TokenFile: file}).Token(&oauth.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "user-token" || token.Secret != "user-secret" {
+		t.Errorf("Unexpected user token %+v", token)
+	}
+}
+
+func TestTokenFileKeepsUnknownFields(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	data := []byte("{\"App\":{\"Token\":\"app-token\",\"Secret\":\"app-secret\"},\"Comment\":\"keep me\",\"Settings\":{\"x\":1}}")
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&ClientTokens{TokenFile: file}).AddProfile("bot", &TokenProfile{}); err != nil {
+		t.Fatal(err)
+	}
+
+	fields := map[string]json.RawMessage{}
+	cf, _ := ioutil.ReadFile(file)
+	if err := json.Unmarshal(cf, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if string(fields["Comment"]) != "\"keep me\"" || string(fields["Settings"]) != "{\"x\":1}" {
+		t.Errorf("Unknown fields not preserved, got %s", cf)
+	}
+
+	entries, _ := ioutil.ReadDir(filepath.Dir(file))
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp") {
+			t.Errorf("Temporary file %v left behind", e.Name())
+		}
+	}
+}
+
+func TestTokenFileConcurrentUpdates(t *testing.T) {
+	file := newTestTokenFile(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if err := (&ClientTokens{TokenFile: file}).AddProfile(name, &TokenProfile{}); err != nil {
+				t.Error(err)
+			}
+		}(string(rune('a' + i)))
+	}
+	wg.Wait()
+
+	names, err := (&ClientTokens{TokenFile: file}).ListProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 10 {
+		t.Errorf("Expecting 10 profiles, got %v", names)
+	}
+}
+
+func TestTokenRevoke(t *testing.T) {
+	file := newTestTokenFile(t)
+	tokens := &ClientTokens{TokenFile: file}
+	err := tokens.update(func() {
+		tokens.User = &oauth.Credentials{Token: "user-token", Secret: "user-secret"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Token was already replaced
+	if err := tokens.Revoke(&oauth.Credentials{Token: "old-token"}); err != nil {
+		t.Fatal(err)
+	}
+	if tokens.User == nil {
+		t.Fatal("Not expecting a newer user token to be revoked")
+	}
+
+	if err := tokens.Revoke(&oauth.Credentials{Token: "user-token"}); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := &ClientTokens{TokenFile: file}
+	if err := reloaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.User != nil || reloaded.App == nil {
+		t.Errorf("Expecting only the user token to be removed, got %+v", reloaded)
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// Top level keys identifying stream messages which aren't tweets - https://dev.twitter.com/docs/streaming-apis/messages
+	messageKinds = []string{"delete", "scrub_geo", "limit", "status_withheld", "user_withheld", "disconnect", "warning", "event", "friends"}
+
+	// TwitterStatus fields by lower case JSON name, for StatusView.Status
+	statusFields = jsonFields(reflect.TypeOf(TwitterStatus{}))
+)
+
+// StatusView is a lightweight view over a raw stream message.  Nothing is decoded until a
+// field is asked for, and then only the objects on the way to that field are scanned (not
+// decoded), so consumers needing a few fields of each tweet avoid the cost of a full
+// TwitterStatus.  Values are only validated when they are decoded.
+type StatusView struct {
+	raw json.RawMessage
+	// Top level fields, indexed on first use
+	fields  viewObject
+	indexed bool
+	err     error
+	// Nested objects indexed by Field, by their path
+	nested map[string]viewObject
+}
+
+// A field of a JSON object, sliced from the raw message.
+type viewField struct {
+	name  []byte
+	value json.RawMessage
+}
+
+// The fields of a JSON object in the order they appear.
+type viewObject []viewField
+
+// Returns the value of the named field.
+func (o viewObject) get(name string) (json.RawMessage, bool) {
+	for _, f := range o {
+		if string(f.name) == name {
+			return f.value, true
+		}
+	}
+	return nil, false
+}
+
+// NewStatusView returns a view over a raw message.  raw must not be modified afterwards.
+func NewStatusView(raw []byte) *StatusView {
+	return &StatusView{raw: raw}
+}
+
+// Raw returns the JSON message exactly as it was received from Twitter.
+func (v *StatusView) Raw() json.RawMessage {
+	return v.raw
+}
+
+// Returns the message's top level fields.
+func (v *StatusView) index() (viewObject, error) {
+	if !v.indexed {
+		v.fields, v.err = scanObject(v.raw)
+		v.indexed = true
+	}
+	return v.fields, v.err
+}
+
+// Returns the fields of the nested object at path, scanning value on first use.
+func (v *StatusView) object(path []string, value json.RawMessage) (viewObject, error) {
+	key := strings.Join(path, "\x00")
+	if fields, ok := v.nested[key]; ok {
+		return fields, nil
+	}
+	fields, err := scanObject(value)
+	if err != nil {
+		return nil, err
+	}
+	if v.nested == nil {
+		v.nested = make(map[string]viewObject)
+	}
+	v.nested[key] = fields
+	return fields, nil
+}
+
+// Field returns the raw value at path, which is a list of object keys, e.g. "user",
+// "screen_name".  It returns nil if the field is missing or null.
+func (v *StatusView) Field(path ...string) (json.RawMessage, error) {
+	fields, err := v.index()
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range path {
+		value, ok := fields.get(name)
+		if !ok || string(value) == "null" {
+			return nil, nil
+		}
+		if i == len(path)-1 {
+			return value, nil
+		}
+		if fields, err = v.object(path[:i+1], value); err != nil {
+			return nil, err
+		}
+	}
+	return v.raw, nil
+}
+
+// String returns the string at path, or "" if it is missing or not a string.
+func (v *StatusView) String(path ...string) string {
+	value, err := v.Field(path...)
+	if err != nil || len(value) < 2 || value[0] != '"' {
+		return ""
+	}
+	// Only strings with escapes (or invalid UTF-8 to replace) need decoding
+	if s := value[1 : len(value)-1]; bytes.IndexByte(s, '\\') < 0 && utf8.Valid(s) {
+		return string(s)
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return ""
+	}
+	return s
+}
+
+// ID returns the tweet's id_str.
+func (v *StatusView) ID() string {
+	return v.String("id_str")
+}
+
+// Text returns the tweet's text.
+func (v *StatusView) Text() string {
+	return v.String("text")
+}
+
+// ScreenName returns the screen name of the tweet's author.
+func (v *StatusView) ScreenName() string {
+	return v.String("user", "screen_name")
+}
+
+// Entities decodes the tweet's entities.
+func (v *StatusView) Entities() (entities TwitterEntity, err error) {
+	value, err := v.Field("entities")
+	if err != nil || value == nil {
+		return
+	}
+	err = json.Unmarshal(value, &entities)
+	return
+}
+
+// Kind returns the type of the message: "status" for tweets, otherwise the top level key
+// of the message ("delete", "limit", "status_withheld", "disconnect", "warning" etc.)
+// It returns "" if the message is not recognised or can't be decoded.
+func (v *StatusView) Kind() string {
+	fields, err := v.index()
+	if err != nil {
+		return ""
+	}
+	if _, ok := fields.get("id_str"); ok {
+		return "status"
+	}
+	for _, kind := range messageKinds {
+		if _, ok := fields.get(kind); ok {
+			return kind
+		}
+	}
+	return ""
+}
+
+// Status decodes the message into a TwitterStatus.  When field names are given only those
+// top level fields are decoded, and the rest are left empty.
+func (v *StatusView) Status(fields ...string) (*TwitterStatus, error) {
+	status := new(TwitterStatus)
+	if len(fields) == 0 {
+		if err := json.Unmarshal(v.raw, status); err != nil {
+			return nil, err
+		}
+		return status, nil
+	}
+
+	all, err := v.index()
+	if err != nil {
+		return nil, err
+	}
+	target := reflect.ValueOf(status).Elem()
+	for _, name := range fields {
+		value, ok := all.get(name)
+		if !ok {
+			continue
+		}
+		// As with encoding/json, names are matched case insensitively
+		i, ok := statusFields[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(value, target.Field(i).Addr().Interface()); err != nil {
+			return nil, err
+		}
+	}
+	status.raw = v.raw
+	return status, nil
+}
+
+// Returns the index of each field of a struct type by its lower case JSON name.
+func jsonFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = i
+	}
+	return fields
+}
+
+// Splits a JSON object into its fields, without decoding or validating their values.
+func scanObject(data []byte) (viewObject, error) {
+	i := skipSpace(data, 0)
+	if i == len(data) || data[i] != '{' {
+		return nil, scanError(data, i)
+	}
+	fields := make(viewObject, 0, 32)
+	if i = skipSpace(data, i+1); i < len(data) && data[i] == '}' {
+		return fields, nil
+	}
+	for {
+		if i == len(data) || data[i] != '"' {
+			return nil, scanError(data, i)
+		}
+		end, err := skipValue(data, i)
+		if err != nil {
+			return nil, err
+		}
+		name := data[i+1 : end-1]
+		if bytes.IndexByte(name, '\\') >= 0 {
+			var unescaped string
+			if err := json.Unmarshal(data[i:end], &unescaped); err != nil {
+				return nil, err
+			}
+			name = []byte(unescaped)
+		}
+
+		if i = skipSpace(data, end); i == len(data) || data[i] != ':' {
+			return nil, scanError(data, i)
+		}
+		i = skipSpace(data, i+1)
+		if end, err = skipValue(data, i); err != nil {
+			return nil, err
+		}
+		fields = append(fields, viewField{name: name, value: data[i:end]})
+
+		if i = skipSpace(data, end); i == len(data) {
+			return nil, scanError(data, i)
+		} else if data[i] == '}' {
+			return fields, nil
+		} else if data[i] != ',' {
+			return nil, scanError(data, i)
+		}
+		i = skipSpace(data, i+1)
+	}
+}
+
+// Returns the offset just past the JSON value starting at data[i].
+func skipValue(data []byte, i int) (int, error) {
+	if i == len(data) {
+		return i, scanError(data, i)
+	}
+	switch data[i] {
+	case '"':
+		for j := i + 1; j < len(data); j++ {
+			quote := bytes.IndexByte(data[j:], '"')
+			if quote < 0 {
+				break
+			}
+			j += quote
+			// The quote is escaped if it follows an odd number of backslashes
+			escapes := 0
+			for k := j - 1; data[k] == '\\'; k-- {
+				escapes++
+			}
+			if escapes%2 == 0 {
+				return j + 1, nil
+			}
+		}
+	case '{', '[':
+		depth := 0
+		for j := i; j < len(data); j++ {
+			switch data[j] {
+			case '"':
+				end, err := skipValue(data, j)
+				if err != nil {
+					return end, err
+				}
+				j = end - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				if depth--; depth == 0 {
+					return j + 1, nil
+				}
+			}
+		}
+	default:
+		// Numbers, true, false and null
+		j := i
+		for j < len(data) && strings.IndexByte(",}] \t\r\n", data[j]) < 0 {
+			j++
+		}
+		if j > i {
+			return j, nil
+		}
+	}
+	return len(data), scanError(data, len(data))
+}
+
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\r' || data[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+func scanError(data []byte, i int) error {
+	if i >= len(data) {
+		return io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("invalid character %q in message at offset %d", data[i], i)
+}
+
+// StreamViews creates a new Twitter API stream like Stream, but sends each message as a
+// StatusView without decoding it.  When kinds are given (see StatusView.Kind) only those
+// messages are sent, otherwise every message is.  Backpressure and DecodeWorkers don't
+// apply to views, and views aren't counted by type in Metrics.
+func (s *StreamClient) StreamViews(views chan<- *StatusView, stream *TwitterAPIURL, formValues *url.Values, kinds ...string) {
+	s.metrics.connecting(false)
+	resp, err := s.sendRequest(stream, formValues)
+	if err != nil {
+		s.metrics.requestFailed(err)
+		s.Errors <- err
+		return
+	}
+	resp.Body = s.metrics.countBytes(resp.Body)
+	logEvent(s.Logger, slog.LevelInfo, "stream connected", "url", stream.URL)
+	s.openStream(resp)
+	defer func() {
+		s.closeStream(resp)
+		s.Finished <- struct{}{}
+	}()
+
+	wanted := make(map[string]bool, len(kinds))
+	for _, kind := range kinds {
+		wanted[kind] = true
+	}
+
+	err = splitFrames(resp.Body, func(data []byte) {
+		view := NewStatusView(data)
+		if len(wanted) == 0 {
+			views <- view
+			return
+		}
+		if _, err := view.index(); err != nil {
+			logEvent(s.Logger, slog.LevelWarn, "message not decoded", "url", stream.URL, "error", err)
+			s.metrics.decodeError()
+			s.Errors <- err
+			return
+		}
+		if wanted[view.Kind()] {
+			views <- view
+		}
+	})
+	s.disconnected(stream, err)
+}
@@ -0,0 +1,200 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func readTestTweet(t testing.TB) []byte {
+	tweet, err := ioutil.ReadFile("test_data/tweet.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tweet
+}
+
+func TestStatusViewFields(t *testing.T) {
+	tweet := readTestTweet(t)
+	status := new(TwitterStatus)
+	if err := json.Unmarshal(tweet, status); err != nil {
+		t.Fatal(err)
+	}
+
+	view := NewStatusView(tweet)
+	if view.ID() != status.ID || view.Text() != status.Text || view.ScreenName() != status.User.ScreenName {
+		t.Errorf("Unexpected view %v %q %v", view.ID(), view.Text(), view.ScreenName())
+	}
+	if view.Kind() != "status" {
+		t.Errorf("Expecting status kind, got %q", view.Kind())
+	}
+	entities, err := view.Entities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities.URLs) != len(status.Entities.URLs) || len(entities.Hashtags) != len(status.Entities.Hashtags) {
+		t.Errorf("Unexpected entities %+v", entities)
+	}
+	if value, err := view.Field("user", "missing"); value != nil || err != nil {
+		t.Errorf("Expecting missing field, got %s (%v)", value, err)
+	}
+	if s := view.String("user"); s != "" {
+		t.Errorf("Expecting empty string for an object, got %q", s)
+	}
+}
+
+func TestStatusViewPartialStatus(t *testing.T) {
+	tweet := readTestTweet(t)
+	status, err := NewStatusView(tweet).Status("id_str", "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.ID != "468728009768579073" || status.Text == "" {
+		t.Errorf("Expected fields not decoded: %+v", status)
+	}
+	if status.User.ScreenName != "" || status.Source != "" {
+		t.Error("Unrequested fields decoded")
+	}
+	if string(status.Raw()) != string(tweet) {
+		t.Error("Expecting full raw message")
+	}
+}
+
+func TestStatusViewScan(t *testing.T) {
+	tweet := readTestTweet(t)
+	expected := map[string]json.RawMessage{}
+	if err := json.Unmarshal(tweet, &expected); err != nil {
+		t.Fatal(err)
+	}
+	fields, err := scanObject(tweet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != len(expected) {
+		t.Errorf("Expecting %v fields, got %v", len(expected), len(fields))
+	}
+	for _, f := range fields {
+		if string(expected[string(f.name)]) != string(f.value) {
+			t.Errorf("Field %s: expecting %s, got %s", f.name, expected[string(f.name)], f.value)
+		}
+	}
+
+	view := NewStatusView([]byte(`{"a\u0062": 1 ,"b":"\\","text":"x\"y]","user":{"screen_name":"g","n":{"x":[1,{"y":"]}"}]}},"e":{}, "f":null}`))
+	testData := []struct {
+		path     []string
+		expected string
+	}{
+		{[]string{"ab"}, "1"},
+		{[]string{"b"}, `"\\"`},
+		{[]string{"text"}, `"x\"y]"`},
+		{[]string{"user", "screen_name"}, `"g"`},
+		{[]string{"user", "n", "x"}, `[1,{"y":"]}"}]`},
+		{[]string{"e", "x"}, ""},
+		{[]string{"f"}, ""},
+	}
+	for _, d := range testData {
+		value, err := view.Field(d.path...)
+		if err != nil || string(value) != d.expected {
+			t.Errorf("%v: expecting %v, got %s (%v)", d.path, d.expected, value, err)
+		}
+	}
+	if view.Text() != `x"y]` || view.ScreenName() != "g" {
+		t.Errorf("Unexpected strings %q %q", view.Text(), view.ScreenName())
+	}
+
+	for _, msg := range []string{`{"id_str":"1","text":"abc`, `{"id_str" "1"}`, `{"id_str":}`, `["id_str"]`, ``} {
+		if _, err := NewStatusView([]byte(msg)).Field("text"); err == nil {
+			t.Errorf("%q: expecting error", msg)
+		}
+	}
+}
+
+func TestStatusViewPartialStatusNested(t *testing.T) {
+	status, err := NewStatusView(readTestTweet(t)).Status("user", "entities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.User.ScreenName == "" || len(status.Entities.Hashtags) == 0 {
+		t.Errorf("Expected fields not decoded: %+v", status)
+	}
+	if status.ID != "" || status.Text != "" {
+		t.Error("Unrequested fields decoded")
+	}
+}
+
+func TestStatusViewKinds(t *testing.T) {
+	testData := []JSONTestData{
+		{"delete", `{"delete":{"status":{"id_str":"1"}}}`, "delete"},
+		{"limit", `{"limit":{"track":10}}`, "limit"},
+		{"withheld", `{"status_withheld":{"id":1}}`, "status_withheld"},
+		{"unknown", `{"x":1}`, ""},
+		{"invalid", `{"x"`, ""},
+	}
+	for _, d := range testData {
+		if kind := NewStatusView([]byte(d.v.(string))).Kind(); kind != d.e {
+			t.Errorf("%v: expecting %q, got %q", d.n, d.e, kind)
+		}
+	}
+}
+
+func TestStreamViewsFiltersKinds(t *testing.T) {
+	body := "{\"id_str\":\"1\",\"text\":\"a\"}\r\n{\"limit\":{\"track\":10}}\r\n\r\n{\"delete\":{}}\r\n{\"id_str\":\"2\",\"text\":\"b\"}\r\n"
+	client := NewClient()
+	views := make(chan *StatusView)
+	go client.StreamViews(views, testStreamURL([]byte(body)), &url.Values{}, "status", "limit")
+
+	kinds := []string{}
+	for {
+		select {
+		case view := <-views:
+			kinds = append(kinds, view.Kind())
+		case <-client.Errors:
+		case <-client.Finished:
+			if len(kinds) != 3 || kinds[0] != "status" || kinds[1] != "limit" || kinds[2] != "status" {
+				t.Errorf("Unexpected messages %v", kinds)
+			}
+			return
+		case <-time.After(time.Second):
+			t.Fatal("Stream timeout")
+		}
+	}
+}
+
+func BenchmarkStatusDecode(b *testing.B) {
+	tweet := readTestTweet(b)
+	b.SetBytes(int64(len(tweet)))
+	for i := 0; i < b.N; i++ {
+		status := new(TwitterStatus)
+		if err := json.Unmarshal(tweet, status); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStatusView(b *testing.B) {
+	tweet := readTestTweet(b)
+	b.SetBytes(int64(len(tweet)))
+	for i := 0; i < b.N; i++ {
+		view := NewStatusView(tweet)
+		if view.ID() == "" || view.ScreenName() == "" {
+			b.Fatal("Missing fields")
+		}
+		if _, err := view.Entities(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStatusViewPartialStatus(b *testing.B) {
+	tweet := readTestTweet(b)
+	b.SetBytes(int64(len(tweet)))
+	for i := 0; i < b.N; i++ {
+		if _, err := NewStatusView(tweet).Status("id_str", "text", "user"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
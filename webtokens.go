@@ -0,0 +1,196 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"fmt"
+	"github.com/garyburd/go-oauth/oauth"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// How long to wait for the user to authorize the app
+	defaultWebTokensTimeout = 5 * time.Minute
+)
+
+// WebTokens is a Tokener which runs Twitter's 3-legged OAuth flow with a real callback URL,
+// instead of asking the user to copy a PIN.
+//
+// If CallbackURL is empty a short-lived HTTP listener is started on ListenAddr to receive
+// the callback.  Otherwise WebTokens should be mounted as the handler for CallbackURL on
+// an existing server:
+/*
+ tokens := &streamingtwitter.WebTokens{
+ 	App:         app,
+ 	CallbackURL: "https://example.com/twitter/callback",
+ 	Open:        redirectUser,
+ }
+ http.Handle("/twitter/callback", tokens)
+ err := client.Authenticate(tokens)
+*/
+type WebTokens struct {
+	// Token for the actual application
+	App *oauth.Credentials
+	// Token for the user of the application.  Set once the flow completes, if it is
+	// already set the flow is skipped.
+	User *oauth.Credentials
+	// Screen name and ID of the user who authorized the app
+	ScreenName string
+	UserID     string
+
+	// URL Twitter redirects the user back to after authorizing the app
+	CallbackURL string
+	// Address of the local callback listener (defaults to 127.0.0.1 on a random port)
+	ListenAddr string
+	// Sends the user to Twitter's authorization URL (defaults to printing it on stdout)
+	Open func(authURL string) error
+	// How long to wait for the callback (defaults to 5 minutes)
+	Timeout time.Duration
+
+	// Guards User, ScreenName, UserID and pending
+	mu      sync.Mutex
+	pending chan url.Values
+	// Held while the flow runs, so clients re-authorizing together share one authorization
+	flow sync.Mutex
+}
+
+// Token returns the user's access token, running the authorization flow if needed.
+func (w *WebTokens) Token(oc *oauth.Client) (*oauth.Credentials, error) {
+	if err := checkAppToken(w.App); err != nil {
+		return nil, err
+	}
+	oc.Credentials = *w.App
+
+	w.flow.Lock()
+	defer w.flow.Unlock()
+	w.mu.Lock()
+	user := w.User
+	w.mu.Unlock()
+	if user != nil && user.Token != "" && user.Secret != "" {
+		return user, nil
+	}
+
+	callback := w.CallbackURL
+	if callback == "" {
+		addr := w.ListenAddr
+		if addr == "" {
+			addr = "127.0.0.1:0"
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		defer l.Close()
+		go http.Serve(l, w)
+
+		callback = "http://" + l.Addr().String() + "/callback"
+	}
+
+	results := make(chan url.Values, 1)
+	w.mu.Lock()
+	w.pending = results
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		w.pending = nil
+		w.mu.Unlock()
+	}()
+
+	tempCredentials, err := oc.RequestTemporaryCredentials(http.DefaultClient, callback, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	open := w.Open
+	if open == nil {
+		open = func(authURL string) error {
+			_, err := fmt.Fprintf(os.Stdout, "Before we can continue ...\nGo to:\n\n\t%s\n\nand authorize the application.\n", authURL)
+			return err
+		}
+	}
+	if err := open(oc.AuthorizationURL(tempCredentials, nil)); err != nil {
+		return nil, err
+	}
+
+	timeout := w.Timeout
+	if timeout == 0 {
+		timeout = defaultWebTokensTimeout
+	}
+
+	var v url.Values
+	select {
+	case v = <-results:
+	case <-time.After(timeout):
+		return nil, &ClientTokensError{
+			Msg: "timed out waiting for authorization",
+		}
+	}
+
+	if v.Get("denied") != "" {
+		return nil, &ClientTokensError{
+			Msg: "authorization denied",
+		}
+	}
+	if v.Get("oauth_token") != tempCredentials.Token {
+		return nil, &ClientTokensError{
+			Msg: "callback oauth_token does not match the request",
+		}
+	}
+
+	token, values, err := oc.RequestToken(http.DefaultClient, tempCredentials, v.Get("oauth_verifier"))
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	w.User = token
+	w.ScreenName = values.Get("screen_name")
+	w.UserID = values.Get("user_id")
+	w.mu.Unlock()
+	return token, nil
+}
+
+// Revoke discards the user token, so the next call to Token runs the authorization flow
+// again.  A user token which has already replaced token is kept.
+func (w *WebTokens) Revoke(token *oauth.Credentials) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.User == nil || (token != nil && w.User.Token != token.Token) {
+		return nil
+	}
+	w.User = nil
+	w.ScreenName = ""
+	w.UserID = ""
+	return nil
+}
+
+// ServeHTTP receives the OAuth callback from Twitter and hands it to the waiting Token call.
+func (w *WebTokens) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	results := w.pending
+	w.mu.Unlock()
+
+	if results == nil {
+		http.Error(rw, "No authorization in progress.", http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("oauth_verifier") == "" && q.Get("denied") == "" {
+		http.Error(rw, "Missing oauth_verifier.", http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case results <- q:
+		fmt.Fprint(rw, "Authorization complete, you can close this window.")
+	default:
+		http.Error(rw, "Authorization already received.", http.StatusConflict)
+	}
+}
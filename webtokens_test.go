@@ -0,0 +1,170 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"github.com/garyburd/go-oauth/oauth"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testAppToken = &oauth.Credentials{
+	Token:  "app-token",
+	Secret: "app-secret",
+}
+
+// Acts as the user's browser: visits the authorization URL and follows the redirect.
+func followAuthURL(authURL string) error {
+	resp, err := http.Get(authURL)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func TestWebTokensLocalListener(t *testing.T) {
+	_, oc := newTestOAuthServer(t)
+	tokens := &WebTokens{
+		App:  testAppToken,
+		Open: followAuthURL,
+	}
+
+	token, err := tokens.Token(oc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []JSONTestData{
+		{"Token", token.Token, "user-token"},
+		{"Secret", token.Secret, "user-secret"},
+		{"ScreenName", tokens.ScreenName, "gopher"},
+		{"UserID", tokens.UserID, "123"},
+	}
+	for _, d := range testData {
+		if d.v != d.e {
+			t.Errorf("%v: expecting %v, got %v", d.n, d.e, d.v)
+		}
+	}
+}
+
+func TestWebTokensMountedHandler(t *testing.T) {
+	_, oc := newTestOAuthServer(t)
+	tokens := &WebTokens{
+		App:  testAppToken,
+		Open: followAuthURL,
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/twitter/callback", tokens)
+	app := httptest.NewServer(mux)
+	defer app.Close()
+	tokens.CallbackURL = app.URL + "/twitter/callback"
+
+	token, err := tokens.Token(oc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "user-token" {
+		t.Errorf("Expecting user-token, got %v", token.Token)
+	}
+
+	// No authorization in progress
+	resp, err := http.Get(tokens.CallbackURL + "?oauth_token=temp-token&oauth_verifier=1234567")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expecting status 400, got %v", resp.StatusCode)
+	}
+}
+
+func TestWebTokensTimeout(t *testing.T) {
+	_, oc := newTestOAuthServer(t)
+	tokens := &WebTokens{
+		App:     testAppToken,
+		Open:    func(string) error { return nil },
+		Timeout: time.Millisecond,
+	}
+
+	if _, err := tokens.Token(oc); err == nil || err.Error() != "timed out waiting for authorization" {
+		t.Errorf("Expecting timeout error, got %v", err)
+	}
+}
+
+func TestWebTokensExistingUser(t *testing.T) {
+	tokens := &WebTokens{
+		App: testAppToken,
+		User: &oauth.Credentials{
+			Token:  "user-token",
+			Secret: "user-secret",
+		},
+	}
+
+	token, err := tokens.Token(&oauth.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != tokens.User {
+		t.Error("Expecting the existing user token")
+	}
+}
+
+func TestWebTokensSharedByClients(t *testing.T) {
+	defer func(u *TwitterAPIURL) { verifyCredentialsURL = u }(verifyCredentialsURL)
+	rejectOld := &TwitterAPIURL{
+		AccessMethod: "custom",
+		CustomHandler: func(_ *http.Client, token *oauth.Credentials, _ string, _ url.Values) (*http.Response, error) {
+			if token.Token == "old-token" {
+				return &http.Response{StatusCode: 401}, nil
+			}
+			return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader("{}"))}, nil
+		},
+	}
+	verifyCredentialsURL = rejectOld
+
+	var mu sync.Mutex
+	flows := 0
+	_, oc := newTestOAuthServer(t)
+	tokens := &WebTokens{
+		App:  testAppToken,
+		User: &oauth.Credentials{Token: "old-token", Secret: "old-secret"},
+		Open: func(authURL string) error {
+			mu.Lock()
+			flows++
+			mu.Unlock()
+			return followAuthURL(authURL)
+		},
+	}
+
+	// Clients (e.g. stream shards) holding the same revoked token
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		client := NewClient()
+		c := *oc
+		client.oauthClient = &c
+		if err := client.Authenticate(tokens); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.sendRequest(rejectOld, &url.Values{}); err != nil {
+				t.Error(err)
+			}
+			if token := client.currentToken(); token.Token != "user-token" {
+				t.Errorf("Expecting user-token, got %v", token.Token)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if flows != 1 {
+		t.Errorf("Expecting 1 authorization, got %v", flows)
+	}
+}
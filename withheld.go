@@ -0,0 +1,73 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+
+package streamingtwitter
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// Country code used by Twitter when content is withheld in all countries
+	withheldAllCountries = "XX"
+	// Country code used by Twitter when content is withheld due to a DMCA notice
+	withheldDMCA = "XY"
+)
+
+// TwitterWithheldNotice is sent on a stream when a status or user has been withheld.
+// https://dev.twitter.com/docs/streaming-apis/messages#withheld_content_notices
+type TwitterWithheldNotice struct {
+	// Either "status" or "user"
+	Type string `json:"-"`
+	// ID of the withheld status or user
+	ID uint64 `json:"id"`
+	// Owner of the withheld status (not set for user notices)
+	UserID              uint64   `json:"user_id"`
+	WithheldInCountries []string `json:"withheld_in_countries"`
+}
+
+// VisibleIn reports whether the status may be shown to a viewer in country (an ISO 3166-1
+// alpha-2 code).  A status is hidden if either it, or its author, is withheld there.
+func (ts *TwitterStatus) VisibleIn(country string) bool {
+	if ts.WithheldCopyright || withheldIn(ts.WithheldInCountries, country) {
+		return false
+	}
+	return ts.User.VisibleIn(country)
+}
+
+// VisibleIn reports whether the user may be shown to a viewer in country (an ISO 3166-1
+// alpha-2 code).
+func (u *TwitterUser) VisibleIn(country string) bool {
+	return !withheldIn(u.WithheldInCountries, country)
+}
+
+// Whether any of the withheld country codes apply to country.
+func withheldIn(countries []string, country string) bool {
+	for _, c := range countries {
+		if c == withheldAllCountries || c == withheldDMCA || strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the withheld notice contained in a raw stream message, or nil if it isn't one.
+func withheldNotice(raw json.RawMessage) *TwitterWithheldNotice {
+	var msg struct {
+		Status *TwitterWithheldNotice `json:"status_withheld"`
+		User   *TwitterWithheldNotice `json:"user_withheld"`
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return nil
+	}
+	if msg.Status != nil {
+		msg.Status.Type = "status"
+		return msg.Status
+	}
+	if msg.User != nil {
+		msg.User.Type = "user"
+		return msg.User
+	}
+	return nil
+}
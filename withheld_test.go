@@ -0,0 +1,82 @@
+// Copyright 2014 JustAdam (adambell7@gmail.com).  All rights reserved.
+// License: MIT
+package streamingtwitter
+
+import (
+	"bytes"
+	"github.com/garyburd/go-oauth/oauth"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestStatusVisibleIn(t *testing.T) {
+	testData := []struct {
+		n string
+		s TwitterStatus
+		c string
+		e bool
+	}{
+		{"not withheld", TwitterStatus{}, "DE", true},
+		{"withheld elsewhere", TwitterStatus{WithheldInCountries: []string{"FR"}}, "DE", true},
+		{"withheld", TwitterStatus{WithheldInCountries: []string{"FR", "DE"}}, "de", false},
+		{"withheld everywhere", TwitterStatus{WithheldInCountries: []string{"XX"}}, "NO", false},
+		{"copyright", TwitterStatus{WithheldCopyright: true, WithheldInCountries: []string{"XY"}}, "NO", false},
+		{"user withheld", TwitterStatus{User: TwitterUser{WithheldInCountries: []string{"NO"}}}, "NO", false},
+	}
+	for _, d := range testData {
+		if d.s.VisibleIn(d.c) != d.e {
+			t.Errorf("%v: expecting VisibleIn(%v) to be %v", d.n, d.c, d.e)
+		}
+	}
+}
+
+func TestStreamSendsWithheldNotices(t *testing.T) {
+	handler := func(*http.Client, *oauth.Credentials, string, url.Values) (*http.Response, error) {
+		resp := &http.Response{
+			Body: ioutil.NopCloser(bytes.NewBufferString("{\"status_withheld\":{\"id\":1234567890,\"user_id\":123456,\"withheld_in_countries\":[\"DE\",\"AR\"]}}\r\n{\"user_withheld\":{\"id\":123456,\"withheld_in_countries\":[\"DE\"]}}\r\n{\"id_str\":\"1\",\"text\":\"text\"}\r\n")),
+		}
+		return resp, nil
+	}
+
+	testurl := &TwitterAPIURL{
+		AccessMethod:  "custom",
+		CustomHandler: handler,
+	}
+
+	client := NewClient()
+	client.Withheld = make(chan *TwitterWithheldNotice)
+	tweets := make(chan *TwitterStatus)
+	go client.Stream(tweets, testurl, &url.Values{})
+	timeout := time.After(5 * time.Millisecond)
+	notices := []*TwitterWithheldNotice{}
+	recTweets := 0
+	for {
+		select {
+		case <-tweets:
+			recTweets++
+		case n := <-client.Withheld:
+			notices = append(notices, n)
+		case <-client.Errors:
+		case <-client.Finished:
+			if recTweets != 1 {
+				t.Errorf("Expecting 1 tweet, got %v", recTweets)
+			}
+			if len(notices) != 2 {
+				t.Fatalf("Expecting 2 withheld notices, got %v", len(notices))
+			}
+			if notices[0].Type != "status" || notices[0].ID != 1234567890 || notices[0].UserID != 123456 || notices[0].WithheldInCountries[1] != "AR" {
+				t.Errorf("Unexpected status withheld notice %+v", notices[0])
+			}
+			if notices[1].Type != "user" || notices[1].ID != 123456 {
+				t.Errorf("Unexpected user withheld notice %+v", notices[1])
+			}
+			return
+		case <-timeout:
+			t.Error("Stream timeout")
+			return
+		}
+	}
+}